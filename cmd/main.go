@@ -35,6 +35,10 @@ const (
 	IndexesDir  = "indexes"
 	SchemaFile  = "schema.sql"
 	SliceDir    = "slices"
+	MismatchDir = "mismatches"
+	CrashDir    = "crashes"
+	FindingDir  = "findings"
+	JournalFile = "journal.jsonl"
 	Config      = "horo.json"
 )
 
@@ -44,6 +48,11 @@ var (
 
 	notSaveOptions bool
 
+	recordFile string
+	replayFile string
+	recorder   *executor.Recorder
+	replay     *executor.Replay
+
 	/// pre initialized components
 	Pool     executor.Pool
 	Database *database.Database
@@ -136,12 +145,25 @@ func mainApp() *cli.App {
 				Usage:       "do not save options",
 				Destination: &notSaveOptions,
 			},
+			&cli.StringFlag{
+				Name:        "record",
+				Usage:       "record all statements with their responses into `FILE`",
+				Destination: &recordFile,
+			},
+			&cli.StringFlag{
+				Name:        "replay",
+				Usage:       "answer statements from `FILE` recorded by --record instead of connecting to databases",
+				Destination: &replayFile,
+			},
 		},
 		Before: func(context *cli.Context) (err error) {
 			if err = setupLogger(); err != nil {
 				return
 			}
-			Pool, err = executor.NewPool(mainOptions.Dsn, &mainOptions.Pool)
+			if err = initRecordReplay(); err != nil {
+				return
+			}
+			Pool, err = newPool(mainOptions.Dsn, &mainOptions.Pool)
 			if err != nil {
 				return
 			}
@@ -150,6 +172,14 @@ func mainApp() *cli.App {
 			return
 		},
 		After: func(*cli.Context) error {
+			if recorder != nil {
+				if err := recorder.Close(); err != nil {
+					log.WithFields(log.Fields{
+						"file": recordFile,
+						"err":  err.Error(),
+					}).Warn("fail to close the record file")
+				}
+			}
 			if !notSaveOptions {
 				config, err := json.MarshalIndent(&options, "", "    ")
 				if err != nil {
@@ -198,6 +228,31 @@ func setupLogger() error {
 	return nil
 }
 
+func initRecordReplay() (err error) {
+	if recordFile != "" && replayFile != "" {
+		return fmt.Errorf("--record and --replay cannot be used together")
+	}
+	if recordFile != "" {
+		recorder, err = executor.NewRecorder(recordFile)
+	}
+	if replayFile != "" {
+		replay, err = executor.LoadReplay(replayFile)
+	}
+	return
+}
+
+// newPool opens a pool on dsn, which is recorded or replayed if it is required
+func newPool(dsn string, poolOptions *executor.PoolOptions) (pool executor.Pool, err error) {
+	if replay != nil {
+		return replay.Pool(dsn), nil
+	}
+	pool, err = executor.NewPool(dsn, poolOptions)
+	if err != nil || recorder == nil {
+		return
+	}
+	return recorder.Pool(pool), nil
+}
+
 func InitDatabase(exec executor.Executor) (db *database.Database, err error) {
 	dbName, err := exec.Query("SELECT DATABASE()")
 	if err != nil {
@@ -19,9 +19,22 @@ import (
 
 	"github.com/chaos-mesh/horoscope/pkg/executor"
 	"github.com/chaos-mesh/horoscope/pkg/generator"
+	"github.com/chaos-mesh/horoscope/pkg/horoscope"
 )
 
 var (
+	parallelisms = map[string]horoscope.Parallelism{
+		"none":   horoscope.ParallelNone,
+		"verify": horoscope.ParallelVerify,
+		"full":   horoscope.ParallelFull,
+	}
+
+	outlierPolicies = map[string]horoscope.OutlierPolicy{
+		"iqr":  horoscope.OutlierIQR,
+		"mad":  horoscope.OutlierMAD,
+		"none": horoscope.OutlierNone,
+	}
+
 	options = Options{
 		Main: MainOptions{
 			Workload: "workload",
@@ -38,6 +51,17 @@ var (
 			Round:             1,
 			MaxPlans:          1000,
 			IgnoreServerError: false,
+			DiffLimit:         horoscope.DefaultDiffLimit,
+			Retries:           3,
+			RetryBackoff:      10 * time.Second,
+			RetryMaxBackoff:   2 * time.Minute,
+			CrashRecovery:     0,
+			Parallelism:       "none",
+			QueryWorkers:      1,
+			Outlier:           "iqr",
+			Judge:             "ttest",
+			Alpha:             horoscope.DefaultAlpha,
+			Threshold:         horoscope.DefaultThreshold,
 		},
 		Card: CardOptions{
 			Typ: "emq",
@@ -88,18 +112,47 @@ type (
 	}
 
 	TestOptions struct {
-		Round                   uint     `json:"round"`
-		NeedPrepare             bool     `json:"need_prepare"`
-		DisableCollectCardError bool     `json:"disable_collect_card_error"`
-		NoBench                 bool     `json:"no_bench"`
-		NoVerify                bool     `json:"no_verify"`
-		ReportFmt               string   `json:"report_fmt"`
-		MaxPlans                uint64   `json:"max_plans"`
-		DifferentialDsn         []string `json:"differential_dsn"`
-		IgnoreServerError       bool     `json:"ignore_server_error"`
-		ExplicitTxn             bool     `json:"explicit_txn"`
+		Round                   uint          `json:"round"`
+		MaxRounds               uint          `json:"max_rounds"`
+		Warmup                  uint          `json:"warmup"`
+		Outlier                 string        `json:"outlier"`
+		Judge                   string        `json:"judge"`
+		Alpha                   float64       `json:"alpha"`
+		Threshold               float64       `json:"threshold"`
+		NeedPrepare             bool          `json:"need_prepare"`
+		DisableCollectCardError bool          `json:"disable_collect_card_error"`
+		NoBench                 bool          `json:"no_bench"`
+		NoVerify                bool          `json:"no_verify"`
+		ReportFmt               string        `json:"report_fmt"`
+		MaxPlans                uint64        `json:"max_plans"`
+		DifferentialDsn         []string      `json:"differential_dsn"`
+		DifferentialSessions    DsnSessions   `json:"differential_sessions,omitempty"`
+		IgnoreServerError       bool          `json:"ignore_server_error"`
+		ExplicitTxn             bool          `json:"explicit_txn"`
+		PlanTimeout             time.Duration `json:"plan_timeout"`
+		PlanTimeoutFactor       float64       `json:"plan_timeout_factor"`
+		Epsilon                 float64       `json:"epsilon"`
+		Collation               string        `json:"collation"`
+		DiffLimit               uint          `json:"diff_limit"`
+		StreamVerify            bool          `json:"stream_verify"`
+		SpillDir                string        `json:"spill_dir"`
+		PreparedParamSets       uint          `json:"prepared_param_sets"`
+		Retries                 uint          `json:"retries"`
+		RetryBackoff            time.Duration `json:"retry_backoff"`
+		RetryMaxBackoff         time.Duration `json:"retry_max_backoff"`
+		CrashRecovery           time.Duration `json:"crash_recovery"`
+		ServerMetrics           bool          `json:"server_metrics"`
+		ConnAffinity            bool          `json:"conn_affinity"`
+		Capture                 bool          `json:"capture"`
+		StatusAddr              string        `json:"status_addr"`
+		Parallelism             string        `json:"parallelism"`
+		QueryWorkers            uint          `json:"query_workers"`
+		Interleave              bool          `json:"interleave"`
 	}
 
+	// DsnSessions maps DSNs to their session variables
+	DsnSessions map[string]map[string]string
+
 	CardOptions struct {
 		Columns string        `json:"columns"`
 		Typ     string        `json:"type"`
@@ -139,9 +192,61 @@ type (
 	}
 )
 
-func (options *TestOptions) Validate() error {
+// reservedConns are connections of the main pool left to `KILL` statements of timeouts and uptime probes
+const reservedConns = 1
+
+// Concurrency is the cap of concurrent plans on the main pool, connections are reserved to the executor held by
+// each query worker and to reservedConns. Zero means no cap, like an unlimited pool
+func (options *TestOptions) Concurrency(pool executor.PoolOptions) int {
+	if pool.MaxOpenConns == 0 {
+		return 0
+	}
+	return int(pool.MaxOpenConns) - int(options.QueryWorkers) - reservedConns
+}
+
+func (options *TestOptions) Validate(pool executor.PoolOptions) error {
 	if options.Round == 0 {
 		return fmt.Errorf("test round cannot be zero")
 	}
+	if options.MaxRounds != 0 && options.MaxRounds < options.Round {
+		return fmt.Errorf("max rounds should be zero or not less than round")
+	}
+	if options.PlanTimeoutFactor != 0 && options.PlanTimeoutFactor < 1 {
+		return fmt.Errorf("plan timeout factor should be zero or not less than 1")
+	}
+	if options.Epsilon < 0 {
+		return fmt.Errorf("epsilon cannot be negative")
+	}
+	if options.Epsilon > 0 && options.StreamVerify && options.SpillDir == "" {
+		// digests of values within epsilon may differ, spilled results are compared again on mismatches
+		return fmt.Errorf("epsilon with stream verify requires a spill dir")
+	}
+	if _, err := horoscope.NewJudge(options.Judge, options.Alpha, options.Threshold); err != nil {
+		return err
+	}
+	if options.Alpha <= 0 || options.Alpha >= 1 {
+		return fmt.Errorf("alpha should be in (0, 1)")
+	}
+	if options.Threshold <= 0 || options.Threshold > 1 {
+		return fmt.Errorf("threshold should be in (0, 1]")
+	}
+	if _, ok := outlierPolicies[options.Outlier]; !ok {
+		return fmt.Errorf("unknown outlier policy: %s", options.Outlier)
+	}
+	if _, ok := parallelisms[options.Parallelism]; !ok {
+		return fmt.Errorf("unknown parallelism: %s", options.Parallelism)
+	}
+	if options.QueryWorkers == 0 {
+		return fmt.Errorf("query workers cannot be zero")
+	}
+	if options.QueryWorkers > 1 && options.Parallelism == "none" {
+		return fmt.Errorf("query workers require a parallelism other than none")
+	}
+	if pool.MaxOpenConns != 0 && int(pool.MaxOpenConns) < int(options.QueryWorkers)+reservedConns {
+		return fmt.Errorf("max open conns should be at least query workers + %d", reservedConns)
+	}
+	if options.Parallelism == "full" && pool.MaxOpenConns != 0 && options.Concurrency(pool) < 1 {
+		return fmt.Errorf("max open conns should be at least query workers + %d for full parallelism", reservedConns+1)
+	}
 	return nil
 }
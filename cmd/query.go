@@ -61,7 +61,7 @@ func queryCommand() *cli.Command {
 				return err
 			}
 
-			dur, rows, err := horoscope.RunSQLWithTime(Pool.Executor(), 1, plan, tp)
+			dur, rows, err := horoscope.RunSQLWithTime(Pool.Executor(), 1, plan, tp, 0)
 			if err != nil {
 				return err
 			}
@@ -16,8 +16,11 @@ package main
 import (
 	"fmt"
 	"io/ioutil"
+	"os"
+	"os/signal"
 	"path"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -30,6 +33,9 @@ import (
 
 var (
 	testOptions = &options.Test
+	// resume and newJournal are not saved in options, otherwise every later run would resume or rotate the journal
+	resume     bool
+	newJournal bool
 
 	differentialPools = make([]executor.Pool, 0)
 )
@@ -41,7 +47,7 @@ func testCommand() *cli.Command {
 		Usage:  "test the optimizer",
 		Action: test,
 		Before: func(context *cli.Context) error {
-			if err := testOptions.Validate(); err != nil {
+			if err := testOptions.Validate(mainOptions.Pool); err != nil {
 				return err
 			}
 			if err := initDifferentialDsn(differentialDsn.Value()); err != nil {
@@ -54,6 +60,16 @@ func testCommand() *cli.Command {
 			return rollback(ctx)
 		},
 		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:        "resume",
+				Usage:       "skip queries finished in the journal of the last run and report them together",
+				Destination: &resume,
+			},
+			&cli.BoolFlag{
+				Name:        "new-journal",
+				Usage:       "keep the journal of the last run aside and start a new one",
+				Destination: &newJournal,
+			},
 			&cli.BoolFlag{
 				Name:        "prepare",
 				Aliases:     []string{"p"},
@@ -68,6 +84,42 @@ func testCommand() *cli.Command {
 				Value:       testOptions.Round,
 				Destination: &testOptions.Round,
 			},
+			&cli.UintFlag{
+				Name:        "max-rounds",
+				Usage:       "add rounds to plans undecided by the judge up to `numbers` rounds, zero means fixed rounds",
+				Value:       testOptions.MaxRounds,
+				Destination: &testOptions.MaxRounds,
+			},
+			&cli.UintFlag{
+				Name:        "warmup",
+				Usage:       "run each plan `numbers` of times before its timed rounds, warmup executions are not measured",
+				Value:       testOptions.Warmup,
+				Destination: &testOptions.Warmup,
+			},
+			&cli.StringFlag{
+				Name:        "outlier",
+				Usage:       "reject outliers of execution times by `iqr`, `mad` or `none` before statistics are computed",
+				Value:       testOptions.Outlier,
+				Destination: &testOptions.Outlier,
+			},
+			&cli.StringFlag{
+				Name:        "judge",
+				Usage:       "judge better plans by `ttest`, `utest`, `bootstrap` confidence intervals of the ratio of medians, or the mean `threshold` only",
+				Value:       testOptions.Judge,
+				Destination: &testOptions.Judge,
+			},
+			&cli.Float64Flag{
+				Name:        "alpha",
+				Usage:       "the significance `LEVEL` of judges",
+				Value:       testOptions.Alpha,
+				Destination: &testOptions.Alpha,
+			},
+			&cli.Float64Flag{
+				Name:        "threshold",
+				Usage:       "a better plan costs less than `RATIO` of the default plan",
+				Value:       testOptions.Threshold,
+				Destination: &testOptions.Threshold,
+			},
 			&cli.Uint64Flag{
 				Name:        "max-plans",
 				Usage:       "the max `numbers` of plans",
@@ -108,6 +160,120 @@ func testCommand() *cli.Command {
 				Value:       testOptions.NoBench,
 				Destination: &testOptions.NoBench,
 			},
+			&cli.DurationFlag{
+				Name:        "plan-timeout",
+				Usage:       "interrupt an execution of a plan after `DURATION`, zero means no limit",
+				Value:       testOptions.PlanTimeout,
+				Destination: &testOptions.PlanTimeout,
+			},
+			&cli.Float64Flag{
+				Name:        "plan-timeout-factor",
+				Usage:       "censor a plan running longer than `K` times of the default plan, zero means disabled",
+				Value:       testOptions.PlanTimeoutFactor,
+				Destination: &testOptions.PlanTimeoutFactor,
+			},
+			&cli.Float64Flag{
+				Name:        "epsilon",
+				Usage:       "the relative `TOLERANCE` of FLOAT and DOUBLE values in verification",
+				Value:       testOptions.Epsilon,
+				Destination: &testOptions.Epsilon,
+			},
+			&cli.StringFlag{
+				Name:        "collation",
+				Usage:       "compare strings under `COLLATION` in verification, `*_ci` ignores case, empty compares bytes",
+				Value:       testOptions.Collation,
+				Destination: &testOptions.Collation,
+			},
+			&cli.UintFlag{
+				Name:        "diff-limit",
+				Usage:       "the max `numbers` of rows or cells in each part of a result diff",
+				Value:       testOptions.DiffLimit,
+				Destination: &testOptions.DiffLimit,
+			},
+			&cli.BoolFlag{
+				Name:        "stream-verify",
+				Usage:       "verify results by digests computed while streaming instead of keeping them in memory",
+				Value:       testOptions.StreamVerify,
+				Destination: &testOptions.StreamVerify,
+			},
+			&cli.StringFlag{
+				Name:        "spill-dir",
+				Usage:       "spill full results of stream verification into `DIR` for result diffs and comparisons within --epsilon",
+				Value:       testOptions.SpillDir,
+				Destination: &testOptions.SpillDir,
+			},
+			&cli.UintFlag{
+				Name:        "prepared-param-sets",
+				Usage:       "verify the query as a prepared statement with `numbers` of parameter sets, zero means disabled",
+				Value:       testOptions.PreparedParamSets,
+				Destination: &testOptions.PreparedParamSets,
+			},
+			&cli.UintFlag{
+				Name:        "retries",
+				Usage:       "retry a query failed with transient network errors or server crashes up to `numbers` times",
+				Value:       testOptions.Retries,
+				Destination: &testOptions.Retries,
+			},
+			&cli.DurationFlag{
+				Name:        "retry-backoff",
+				Usage:       "wait `DURATION` before the first retry, it doubles after each retry",
+				Value:       testOptions.RetryBackoff,
+				Destination: &testOptions.RetryBackoff,
+			},
+			&cli.DurationFlag{
+				Name:        "retry-max-backoff",
+				Usage:       "the max `DURATION` to wait before a retry, zero means no limit",
+				Value:       testOptions.RetryMaxBackoff,
+				Destination: &testOptions.RetryMaxBackoff,
+			},
+			&cli.DurationFlag{
+				Name:        "crash-recovery",
+				Usage:       "wait the server to come back for `DURATION` after a plan lost the connection to detect crashes, zero means disabled",
+				Value:       testOptions.CrashRecovery,
+				Destination: &testOptions.CrashRecovery,
+			},
+			&cli.BoolFlag{
+				Name:        "capture",
+				Usage:       "capture optimizer traces and plan replayer bundles of queries with better plans into the workload",
+				Value:       testOptions.Capture,
+				Destination: &testOptions.Capture,
+			},
+			&cli.StringFlag{
+				Name:        "status-addr",
+				Usage:       "download plan replayer bundles from the status `ADDRESS` of TiDB, like 127.0.0.1:10080",
+				Value:       testOptions.StatusAddr,
+				Destination: &testOptions.StatusAddr,
+			},
+			&cli.BoolFlag{
+				Name:        "conn-affinity",
+				Usage:       "run all rounds of plans of a query on a dedicated connection",
+				Value:       testOptions.ConnAffinity,
+				Destination: &testOptions.ConnAffinity,
+			},
+			&cli.BoolFlag{
+				Name:        "server-metrics",
+				Usage:       "collect server-side latency, processed keys, memory and CPU time of each round by an extra EXPLAIN ANALYZE",
+				Value:       testOptions.ServerMetrics,
+				Destination: &testOptions.ServerMetrics,
+			},
+			&cli.StringFlag{
+				Name:        "parallelism",
+				Usage:       "`none` runs statements one after another, `verify` overlaps verification and plan collection with serial timing, `full` also runs plans concurrently up to --max-open-conns",
+				Value:       testOptions.Parallelism,
+				Destination: &testOptions.Parallelism,
+			},
+			&cli.UintFlag{
+				Name:        "query-workers",
+				Usage:       "test `numbers` of queries concurrently, requires --parallelism",
+				Value:       testOptions.QueryWorkers,
+				Destination: &testOptions.QueryWorkers,
+			},
+			&cli.BoolFlag{
+				Name:        "interleave",
+				Usage:       "run a round of every plan before the next round to spread drift of the cluster over plans",
+				Value:       testOptions.Interleave,
+				Destination: &testOptions.Interleave,
+			},
 			&cli.BoolFlag{
 				Name:        "no-cardinality-error",
 				Usage:       "collect cardinality estimation error",
@@ -128,11 +294,117 @@ func test(*cli.Context) error {
 	if err != nil {
 		return err
 	}
+	journalFile := path.Join(mainOptions.Workload, JournalFile)
+	journalMode := horoscope.JournalNew
+	switch {
+	case resume && newJournal:
+		return fmt.Errorf("--resume and --new-journal cannot be used together")
+	case resume:
+		journalMode = horoscope.JournalResume
+	case newJournal:
+		journalMode = horoscope.JournalRotate
+	}
+	journal, resumed, err := horoscope.OpenJournal(journalFile, journalMode)
+	if err != nil && journalMode == horoscope.JournalNew {
+		return fmt.Errorf("open journal %s error: %v, resume the test by --resume or start a new journal by --new-journal", journalFile, err)
+	}
+	if err != nil {
+		return fmt.Errorf("open journal %s error: %v", journalFile, err)
+	}
+	defer journal.Close()
+	if resume {
+		finished := make(map[string]bool, len(resumed))
+		for _, row := range resumed {
+			finished[row.QueryId] = true
+		}
+		newLoader = &loader.SkipLoader{QueryLoader: newLoader, Skip: finished}
+		log.WithFields(log.Fields{
+			"journal":  journalFile,
+			"finished": len(resumed),
+		}).Info("resume the test")
+	}
 
-	horo := horoscope.NewHoroscope(Pool, differentialPools, newLoader, !testOptions.DisableCollectCardError)
+	judge, err := horoscope.NewJudge(testOptions.Judge, testOptions.Alpha, testOptions.Threshold)
+	if err != nil {
+		return err
+	}
+	var captureDir string
+	if testOptions.Capture {
+		captureDir = path.Join(mainOptions.Workload, FindingDir)
+	}
+	horo := horoscope.NewHoroscope(Pool, differentialPools, newLoader, !testOptions.DisableCollectCardError, horoscope.Options{
+		PlanTimeout:       testOptions.PlanTimeout,
+		PlanTimeoutFactor: testOptions.PlanTimeoutFactor,
+		Compare: executor.CompareOptions{
+			Epsilon:   testOptions.Epsilon,
+			Collation: testOptions.Collation,
+		},
+		DiffLimit:         int(testOptions.DiffLimit),
+		StreamVerify:      testOptions.StreamVerify,
+		SpillDir:          testOptions.SpillDir,
+		PreparedParamSets: int(testOptions.PreparedParamSets),
+		Retry: executor.RetryPolicy{
+			MaxRetries: int(testOptions.Retries),
+			Backoff:    testOptions.RetryBackoff,
+			MaxBackoff: testOptions.RetryMaxBackoff,
+		},
+		CrashRecovery: testOptions.CrashRecovery,
+		ServerMetrics: testOptions.ServerMetrics,
+		ConnAffinity:  testOptions.ConnAffinity,
+		CaptureDir:    captureDir,
+		StatusAddr:    testOptions.StatusAddr,
+		Parallelism:   parallelisms[testOptions.Parallelism],
+		Concurrency:   testOptions.Concurrency(mainOptions.Pool),
+		Interleave:    testOptions.Interleave,
+		MaxRounds:     testOptions.MaxRounds,
+		Warmup:        testOptions.Warmup,
+		Outlier:       outlierPolicies[testOptions.Outlier],
+		Judge:         judge,
+	})
 	collection := make(horoscope.BenchCollection, 0)
+	output := func() error {
+		table := collection.Table()
+		table.Rows = append(resumed, table.Rows...)
+		table.Sessions, table.Judge = horo.Sessions(), horoscope.SequentialJudge(judge, testOptions.Round, testOptions.MaxRounds).String()
+		return table.Output(testOptions.ReportFmt)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	results := nextBenches(horo, testOptions.QueryWorkers, done)
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
 	for {
-		benches, err := horo.Next(testOptions.Round, testOptions.MaxPlans, !testOptions.NoVerify, testOptions.IgnoreServerError)
+		var result testResult
+		select {
+		case r, ok := <-results:
+			if !ok {
+				if !testOptions.NoBench {
+					return output()
+				}
+				return nil
+			}
+			result = r
+		case <-interrupt:
+			log.WithFields(log.Fields{
+				"journal": journalFile,
+			}).Warn("interrupted, resume the test by --resume")
+			if !testOptions.NoBench {
+				if err := output(); err != nil {
+					return err
+				}
+			}
+			return fmt.Errorf("test is interrupted")
+		}
+		benches, err := result.benches, result.err
+		if benches != nil && len(benches.Crashes) != 0 {
+			if err := saveCrashes(mainOptions.Workload, benches, horo.Sessions()[0]); err != nil {
+				log.WithFields(log.Fields{
+					"query id": benches.QueryID,
+					"err":      err.Error(),
+				}).Warn("fail to save the crash reproduction")
+			}
+		}
 		if err != nil {
 			if benches != nil {
 				log.WithFields(log.Fields{
@@ -145,9 +417,40 @@ func test(*cli.Context) error {
 					"err": err.Error(),
 				}).Warn("Occurs an error when testing one query")
 			}
-			if strings.Contains(err.Error(), "connection refused") ||
-				strings.Contains(err.Error(), "invalid connection") {
-				time.Sleep(2 * time.Minute)
+			if benches != nil && benches.Mismatch != nil {
+				if err := saveMismatch(mainOptions.Workload, benches); err != nil {
+					log.WithFields(log.Fields{
+						"query id": benches.QueryID,
+						"err":      err.Error(),
+					}).Warn("fail to save the result diff")
+				}
+			}
+			if executor.IsTimeout(err) {
+				if benches == nil {
+					log.WithFields(log.Fields{
+						"timeout": testOptions.PlanTimeout,
+					}).Warn("plan timed out, skip one query")
+					continue
+				}
+				log.WithFields(log.Fields{
+					"query id": benches.QueryID,
+					"timeout":  testOptions.PlanTimeout,
+				}).Warn("plan timed out, skip the query")
+				// a timed-out query is reported and journaled, so that it is not tested again on resume
+				collection = append(collection, benches)
+				if err := journal.Append(benches); err != nil {
+					log.WithFields(log.Fields{
+						"query id": benches.QueryID,
+						"err":      err.Error(),
+					}).Warn("fail to append the query to the journal")
+				}
+				continue
+			}
+			if class := executor.Classify(err); class.Transient() {
+				log.WithFields(log.Fields{
+					"class": class,
+					"err":   err.Error(),
+				}).Warn("skip the query after retries")
 				continue
 			}
 			if _, serverError := err.(horoscope.ServerError); serverError && testOptions.IgnoreServerError {
@@ -156,7 +459,7 @@ func test(*cli.Context) error {
 			return err
 		}
 		if benches == nil {
-			break
+			continue
 		}
 		log.WithFields(log.Fields{
 			"query id":      benches.QueryID,
@@ -165,19 +468,27 @@ func test(*cli.Context) error {
 			"default hints": benches.DefaultPlan.Hints,
 			"cost":          fmt.Sprintf("%v", benches.DefaultPlan.Cost.Values),
 			"plan size":     len(benches.Plans),
+			"raw plan size": benches.RawPlanCount,
 		}).Info("Complete a step")
 		log.WithFields(log.Fields{
 			"query id":    benches.QueryID,
 			"explanation": benches.DefaultPlan.Explanation.String(),
 		}).Debug("Default explanation")
 		collection = append(collection, benches)
+		if err := journal.Append(benches); err != nil {
+			log.WithFields(log.Fields{
+				"query id": benches.QueryID,
+				"err":      err.Error(),
+			}).Warn("fail to append the query to the journal")
+		}
 		if !testOptions.NoBench {
 			for _, plan := range benches.Plans {
-				if horoscope.IsSubOptimal(&benches.DefaultPlan, plan) && plan.Plan != benches.DefaultPlan.Plan {
+				if benches.Verdict(plan).Better && plan.Plan != benches.DefaultPlan.Plan {
 					log.WithFields(log.Fields{
 						"query id":     benches.QueryID,
 						"better plan":  plan.Plan,
 						"better hints": plan.Hints,
+						"hints diff":   benches.DefaultPlan.Hints.Diff(plan.Hints),
 					}).Errorf(
 						"may choose a suboptimal plan(%0.2fms < %0.2fms)",
 						plan.Cost.Mean,
@@ -191,12 +502,40 @@ func test(*cli.Context) error {
 			}
 		}
 	}
+}
 
-	if !testOptions.NoBench {
-		return collection.Output(testOptions.ReportFmt)
-	}
+type testResult struct {
+	benches *horoscope.Benches
+	err     error
+}
 
-	return nil
+// nextBenches tests queries by workers until all queries are tested or done is closed,
+// the channel is closed once all workers exit
+func nextBenches(horo *horoscope.Horoscope, workers uint, done <-chan struct{}) <-chan testResult {
+	results := make(chan testResult)
+	var wg sync.WaitGroup
+	for i := uint(0); i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				benches, err := horo.Next(testOptions.Round, testOptions.MaxPlans, !testOptions.NoVerify, testOptions.IgnoreServerError)
+				select {
+				case results <- testResult{benches: benches, err: err}:
+				case <-done:
+					return
+				}
+				if benches == nil && err == nil {
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	return results
 }
 
 func prepare(workloadDir string, exec executor.Executor) error {
@@ -219,9 +558,63 @@ func prepare(workloadDir string, exec executor.Executor) error {
 	return nil
 }
 
+// saveMismatch writes the result diff of a failed verification to the workload
+func saveMismatch(workloadDir string, benches *horoscope.Benches) error {
+	dir := path.Join(workloadDir, MismatchDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	mismatch := benches.Mismatch
+	content := fmt.Sprintf("-- query id: %s\n-- default plan:\n%s;\n-- mismatched with %s:\n%s;\n\n%s",
+		benches.QueryID, benches.DefaultPlan.SQL, mismatch.Source, mismatch.SQL, mismatch.Diff.String())
+	file := path.Join(dir, fmt.Sprintf("%s.diff", benches.QueryID))
+	log.WithFields(log.Fields{
+		"query id": benches.QueryID,
+		"file":     file,
+	}).Info("save the result diff")
+	return ioutil.WriteFile(file, []byte(content), 0644)
+}
+
+// saveCrashes writes a reproduction of each crash with the schema, prepare statements and session variables
+func saveCrashes(workloadDir string, benches *horoscope.Benches, session horoscope.Session) error {
+	dir := path.Join(workloadDir, CrashDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	var setup strings.Builder
+	for _, name := range []string{SchemaFile, PrepareFile} {
+		content, err := ioutil.ReadFile(path.Join(workloadDir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&setup, "-- %s\n%s\n", name, strings.TrimSpace(string(content)))
+	}
+	if set := executor.SetStatement(session.Variables); set != "" {
+		fmt.Fprintf(&setup, "%s;\n", set)
+	}
+	for _, crash := range benches.Crashes {
+		content := fmt.Sprintf("-- query id: %s\n-- plan: %d\n-- hints: %s\n-- error: %s\n-- server restarted at: %s\n%s%s;\n",
+			benches.QueryID, crash.Plan, crash.Hints, crash.Err, crash.Restart.Format(time.RFC3339), setup.String(), crash.SQL)
+		file := path.Join(dir, fmt.Sprintf("%s-plan%d.sql", benches.QueryID, crash.Plan))
+		log.WithFields(log.Fields{
+			"query id": benches.QueryID,
+			"file":     file,
+		}).Info("save the crash reproduction")
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func initDifferentialDsn(dsns []string) error {
 	for _, dsn := range dsns {
-		pool, err := executor.NewPool(dsn, &mainOptions.Pool)
+		poolOptions := mainOptions.Pool
+		poolOptions.SessionVariables = testOptions.DifferentialSessions[dsn]
+		pool, err := newPool(dsn, &poolOptions)
 		if err != nil {
 			return fmt.Errorf("fail to open pool on dsn '%s': %s", dsn, err.Error())
 		}
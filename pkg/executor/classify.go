@@ -0,0 +1,138 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package executor
+
+import (
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/pingcap/tidb/errno"
+)
+
+// ErrorClass sorts errors of statements by how a test should react to them
+type ErrorClass uint8
+
+const (
+	// ErrorNone is the class of a nil error
+	ErrorNone ErrorClass = iota
+	// ErrorNetwork means the server is unreachable or a storage node is temporarily unavailable
+	ErrorNetwork
+	// ErrorCrash means the connection broke in the middle of a statement, the server may be restarting
+	ErrorCrash
+	// ErrorSchemaChanged means a concurrent DDL changed the schema the statement was built on
+	ErrorSchemaChanged
+	// ErrorTimeout means the statement is interrupted by its context or the max execution time
+	ErrorTimeout
+	// ErrorResource means the statement exceeds the memory quota or the server runs out of resources
+	ErrorResource
+	// ErrorPlanUnsupported means the server cannot build or execute the plan of the statement
+	ErrorPlanUnsupported
+	// ErrorServer is any other error
+	ErrorServer
+)
+
+var errorClassNames = map[ErrorClass]string{
+	ErrorNone:            "none",
+	ErrorNetwork:         "network",
+	ErrorCrash:           "crash",
+	ErrorSchemaChanged:   "schema changed",
+	ErrorTimeout:         "timeout",
+	ErrorResource:        "resource",
+	ErrorPlanUnsupported: "plan unsupported",
+	ErrorServer:          "server",
+}
+
+var mysqlErrorClasses = map[uint16]ErrorClass{
+	errno.ErrPDServerTimeout:        ErrorNetwork,
+	errno.ErrTiKVServerTimeout:      ErrorNetwork,
+	errno.ErrTiKVServerBusy:         ErrorNetwork,
+	errno.ErrResolveLockTimeout:     ErrorNetwork,
+	errno.ErrRegionUnavailable:      ErrorNetwork,
+	errno.ErrTiKVStoreLimit:         ErrorNetwork,
+	errno.ErrInfoSchemaChanged:      ErrorSchemaChanged,
+	errno.ErrServerShutdown:         ErrorCrash,
+	errno.ErrQueryInterrupted:       ErrorTimeout,
+	errno.ErrMaxExecTimeExceeded:    ErrorTimeout,
+	errno.ErrMemExceedThreshold:     ErrorResource,
+	errno.ErrOutOfResources:         ErrorResource,
+	errno.ErrConCount:               ErrorResource,
+	errno.ErrTooManyUserConnections: ErrorResource,
+	errno.ErrNotSupportedYet:        ErrorPlanUnsupported,
+	errno.ErrUnsupportedType:        ErrorPlanUnsupported,
+}
+
+func (c ErrorClass) String() string {
+	return errorClassNames[c]
+}
+
+func (c ErrorClass) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// Transient means the same statement may succeed if it is retried later
+func (c ErrorClass) Transient() bool {
+	return c == ErrorNetwork || c == ErrorCrash || c == ErrorSchemaChanged
+}
+
+// Classify sorts err by MySQL error numbers and errors of the driver, messages are only matched
+// for errors formatted without wrapping
+func Classify(err error) ErrorClass {
+	if err == nil {
+		return ErrorNone
+	}
+	if IsTimeout(err) {
+		return ErrorTimeout
+	}
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		if class, ok := mysqlErrorClasses[mysqlErr.Number]; ok {
+			return class
+		}
+		return classifyMessage(mysqlErr.Message)
+	}
+
+	if errors.Is(err, mysql.ErrInvalidConn) || errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return ErrorCrash
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return ErrorNetwork
+	}
+	return classifyMessage(err.Error())
+}
+
+func classifyMessage(message string) ErrorClass {
+	message = strings.ToLower(message)
+	switch {
+	case strings.Contains(message, "connection refused"), strings.Contains(message, "no route to host"),
+		strings.Contains(message, "i/o timeout"):
+		return ErrorNetwork
+	case strings.Contains(message, "invalid connection"), strings.Contains(message, "bad connection"),
+		strings.Contains(message, "connection reset"), strings.Contains(message, "broken pipe"):
+		return ErrorCrash
+	case strings.Contains(message, "out of memory quota"), strings.Contains(message, "exceeded the allowed memory"):
+		return ErrorResource
+	case strings.Contains(message, "can't find a proper physical plan"), strings.Contains(message, "not supported"):
+		return ErrorPlanUnsupported
+	}
+	return ErrorServer
+}
@@ -0,0 +1,52 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/pingcap/tidb/errno"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassify(t *testing.T) {
+	for _, testCase := range []struct {
+		err   error
+		class ErrorClass
+	}{
+		{nil, ErrorNone},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, ErrorNetwork},
+		{errors.New("dial tcp 127.0.0.1:4000: connect: connection refused"), ErrorNetwork},
+		{&mysql.MySQLError{Number: errno.ErrTiKVServerTimeout, Message: "TiKV server timeout"}, ErrorNetwork},
+		{&mysql.MySQLError{Number: errno.ErrInfoSchemaChanged, Message: "Information schema is changed"}, ErrorSchemaChanged},
+		{mysql.ErrInvalidConn, ErrorCrash},
+		{fmt.Errorf("explain error: %w", mysql.ErrInvalidConn), ErrorCrash},
+		{&net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, ErrorCrash},
+		{&TimeoutError{Query: "SELECT 1", Cause: context.DeadlineExceeded}, ErrorTimeout},
+		{&mysql.MySQLError{Number: errno.ErrMaxExecTimeExceeded, Message: "Query execution was interrupted, maximum statement execution time exceeded"}, ErrorTimeout},
+		{&mysql.MySQLError{Number: errno.ErrUnknown, Message: "Out Of Memory Quota![conn_id=1]"}, ErrorResource},
+		{&mysql.MySQLError{Number: errno.ErrMemExceedThreshold, Message: "memory exceeds threshold"}, ErrorResource},
+		{&mysql.MySQLError{Number: errno.ErrInternal, Message: "Can't find a proper physical plan for this query"}, ErrorPlanUnsupported},
+		{&mysql.MySQLError{Number: errno.ErrNoSuchTable, Message: "Table 'test.t' doesn't exist"}, ErrorServer},
+	} {
+		class := Classify(testCase.err)
+		assert.Equal(t, testCase.class, class, "%v", testCase.err)
+		assert.Equal(t, testCase.class == ErrorNetwork || testCase.class == ErrorCrash || testCase.class == ErrorSchemaChanged, class.Transient())
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 2, Backoff: time.Second, MaxBackoff: 3 * time.Second}
+	assert.True(t, policy.Retryable(mysql.ErrInvalidConn, 1))
+	assert.False(t, policy.Retryable(mysql.ErrInvalidConn, 2))
+	assert.False(t, policy.Retryable(errors.New("syntax error"), 0))
+	assert.Equal(t, time.Second, policy.Delay(0))
+	assert.Equal(t, 2*time.Second, policy.Delay(1))
+	assert.Equal(t, 3*time.Second, policy.Delay(2))
+	assert.Equal(t, 3*time.Second, policy.Delay(10))
+}
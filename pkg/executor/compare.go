@@ -13,9 +13,186 @@
 
 package executor
 
-import "fmt"
+import (
+	"fmt"
+	"sort"
+)
 
 type Comparable interface {
 	fmt.Stringer
 	Equal(other Comparable) bool
 }
+
+type (
+	// MultisetRows compares rows regardless of their order
+	MultisetRows struct {
+		Rows
+		Options CompareOptions
+		// Limit is the LIMIT of the query, only row counts are compared if it may cut the result
+		Limit *RowLimit
+	}
+
+	// PrefixOrderedRows compares rows in the order of Keys, the result columns of an ORDER BY prefix,
+	// rows with the same keys are compared as a multiset
+	PrefixOrderedRows struct {
+		Rows
+		Keys    []int
+		Options CompareOptions
+		// Limit is the LIMIT of the query, only keys of rows are compared in groups it may cut
+		Limit *RowLimit
+	}
+
+	// RowLimit is the LIMIT of a query, rows tied at the boundaries it cuts may differ between correct plans
+	RowLimit struct {
+		// Count is the row count of LIMIT, zero if it is not a constant
+		Count uint64
+		// Offset is the offset of LIMIT, non-zero if it is not a constant
+		Offset uint64
+	}
+)
+
+// cuts reports whether the limit may cut rows before the first row or after the last row of the result
+func (l *RowLimit) cuts(rows int) (head, tail bool) {
+	if l == nil {
+		return false, false
+	}
+	return l.Offset != 0, l.Count == 0 || uint64(rows) >= l.Count
+}
+
+func (r MultisetRows) Equal(other Comparable) bool {
+	otherRows, ok := rowsOf(other)
+	if !ok || !r.sameShape(otherRows) {
+		return false
+	}
+	if head, tail := r.Limit.cuts(r.RowCount()); head || tail {
+		return true
+	}
+	return r.Options.multisetEqual(r.kinds(), r.Data, otherRows.Data)
+}
+
+func (r PrefixOrderedRows) Equal(other Comparable) bool {
+	otherRows, ok := rowsOf(other)
+	if !ok || !r.sameShape(otherRows) {
+		return false
+	}
+	kinds := r.kinds()
+	head, tail := r.Limit.cuts(r.RowCount())
+	for start := 0; start < len(r.Data); {
+		end := start + 1
+		for end < len(r.Data) && r.sameKeys(kinds, r.Data[start], r.Data[end]) {
+			end++
+		}
+		for i := start; i < end; i++ {
+			if !r.sameKeys(kinds, r.Data[start], otherRows.Data[i]) {
+				return false
+			}
+		}
+		// the limit may keep different rows of the groups it cuts
+		cut := (head && start == 0) || (tail && end == len(r.Data))
+		if !cut && !r.Options.multisetEqual(kinds, r.Data[start:end], otherRows.Data[start:end]) {
+			return false
+		}
+		start = end
+	}
+	return true
+}
+
+func (r PrefixOrderedRows) sameKeys(kinds []valueKind, row, other Row) bool {
+	for _, key := range r.Keys {
+		if r.Options.compareCell(kinds[key], row[key], other[key]) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func rowsOf(c Comparable) (Rows, bool) {
+	switch rows := c.(type) {
+	case Rows:
+		return rows, true
+	case MultisetRows:
+		return rows.Rows, true
+	case PrefixOrderedRows:
+		return rows.Rows, true
+	default:
+		return Rows{}, false
+	}
+}
+
+func (r Rows) sameShape(other Rows) bool {
+	if r.RowCount() != other.RowCount() || r.ColumnNums() != other.ColumnNums() {
+		return false
+	}
+	for i, column := range r.Columns {
+		if string(column) != string(other.Columns[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r Rows) kinds() []valueKind {
+	return columnKinds(r.databaseTypes(), r.ColumnNums())
+}
+
+// multisetEqual sorts copies of both sides and compares them pairwise, so that tolerance still works
+func (o CompareOptions) multisetEqual(kinds []valueKind, rows, other []Row) bool {
+	if len(rows) != len(other) {
+		return false
+	}
+	sorted, otherSorted := o.sortRows(kinds, rows), o.sortRows(kinds, other)
+	for i, row := range sorted {
+		if o.compareRow(kinds, row, otherSorted[i]) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (o CompareOptions) sortRows(kinds []valueKind, rows []Row) []Row {
+	sorted := make([]Row, 0, len(rows))
+	for _, i := range o.sortOrder(kinds, rows) {
+		sorted = append(sorted, rows[i])
+	}
+	return sorted
+}
+
+// sortOrder returns indexes of rows in sorted order. Equality within Epsilon is not transitive, so rows are
+// sorted by exact values of sortColumns, rows equal within the tolerance end up at the same positions of both sides
+func (o CompareOptions) sortOrder(kinds []valueKind, rows []Row) []int {
+	order := make([]int, len(rows))
+	for i := range order {
+		order[i] = i
+	}
+	exact, columns := CompareOptions{Collation: o.Collation}, sortColumns(kinds)
+	sort.SliceStable(order, func(i, j int) bool {
+		return exact.compareColumns(kinds, columns, rows[order[i]], rows[order[j]]) < 0
+	})
+	return order
+}
+
+// sortColumns orders approximate columns last, rows equal within the tolerance have the same values
+// in other columns, so that they are adjacent in the sorted order
+func sortColumns(kinds []valueKind) []int {
+	columns := make([]int, 0, len(kinds))
+	for i, kind := range kinds {
+		if kind != kindApprox {
+			columns = append(columns, i)
+		}
+	}
+	for i, kind := range kinds {
+		if kind == kindApprox {
+			columns = append(columns, i)
+		}
+	}
+	return columns
+}
+
+func (o CompareOptions) compareColumns(kinds []valueKind, columns []int, row, other Row) int {
+	for _, i := range columns {
+		if result := o.compareCell(kinds[i], row[i], other[i]); result != 0 {
+			return result
+		}
+	}
+	return 0
+}
@@ -0,0 +1,249 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package executor
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jedib0t/go-pretty/table"
+
+	"github.com/chaos-mesh/horoscope/pkg/utils"
+)
+
+type (
+	// Differ is a Comparable which can explain how other differs from it
+	Differ interface {
+		Comparable
+		Diff(other Comparable, limit int) ResultDiff
+	}
+
+	// ResultDiff describes how the actual result differs from the expected one,
+	// each list holds at most `limit` items
+	ResultDiff struct {
+		ExpectedRows int        `json:"expectedRows"`
+		ActualRows   int        `json:"actualRows"`
+		Columns      []string   `json:"columns,omitempty"`
+		Missing      [][]string `json:"missing,omitempty"`
+		Extra        [][]string `json:"extra,omitempty"`
+		Changed      []CellDiff `json:"changed,omitempty"`
+		// OrderMismatch means rows are the same but in different order
+		OrderMismatch bool `json:"orderMismatch,omitempty"`
+		Truncated     bool `json:"truncated,omitempty"`
+		// Expected and Actual are set for results which are not rows
+		Expected string `json:"expected,omitempty"`
+		Actual   string `json:"actual,omitempty"`
+	}
+
+	// CellDiff is a value differs from the expected one, Row is the index in the expected rows
+	CellDiff struct {
+		Row      int    `json:"row"`
+		Column   string `json:"column"`
+		Expected string `json:"expected"`
+		Actual   string `json:"actual"`
+	}
+)
+
+// Diff explains how actual differs from expected
+func Diff(expected, actual Comparable, limit int) ResultDiff {
+	if differ, ok := expected.(Differ); ok {
+		return differ.Diff(actual, limit)
+	}
+	return ResultDiff{Expected: expected.String(), Actual: actual.String()}
+}
+
+// Diff compares rows in order, changed rows are reported by cells
+func (r Rows) Diff(other Comparable, limit int) ResultDiff {
+	otherRows, ok := rowsOf(other)
+	if !ok || r.ColumnNums() != otherRows.ColumnNums() {
+		return ResultDiff{Expected: r.String(), Actual: other.String()}
+	}
+	diff := r.newDiff(otherRows)
+	kinds, options := r.kinds(), CompareOptions{}
+	for i, row := range r.Data {
+		if i >= otherRows.RowCount() {
+			diff.addMissing(row, limit)
+			continue
+		}
+		for j, value := range row {
+			if options.compareCell(kinds[j], value, otherRows.Data[i][j]) != 0 {
+				diff.addChanged(i, string(r.Columns[j]), value, otherRows.Data[i][j], limit)
+			}
+		}
+	}
+	for _, row := range otherRows.Data[utils.MinInt(r.RowCount(), otherRows.RowCount()):] {
+		diff.addExtra(row, limit)
+	}
+	return diff
+}
+
+func (r MultisetRows) Diff(other Comparable, limit int) ResultDiff {
+	return r.Options.multisetDiff(r.Rows, other, limit)
+}
+
+func (r PrefixOrderedRows) Diff(other Comparable, limit int) ResultDiff {
+	diff := r.Options.multisetDiff(r.Rows, other, limit)
+	if diff.Expected == "" && len(diff.Missing) == 0 && len(diff.Extra) == 0 {
+		diff.OrderMismatch = true
+	}
+	return diff
+}
+
+// multisetDiff matches rows regardless of order, unmatched rows are paired in sorted order to report changed cells
+func (o CompareOptions) multisetDiff(rows Rows, other Comparable, limit int) ResultDiff {
+	otherRows, ok := rowsOf(other)
+	if !ok || rows.ColumnNums() != otherRows.ColumnNums() {
+		return ResultDiff{Expected: rows.String(), Actual: other.String()}
+	}
+	diff := rows.newDiff(otherRows)
+	kinds := rows.kinds()
+	// missing holds indexes of the expected rows, so that changed cells are reported by them
+	expected, actual := o.sortOrder(kinds, rows.Data), o.sortRows(kinds, otherRows.Data)
+	columns := sortColumns(kinds)
+	var (
+		missing []int
+		extra   []Row
+	)
+	for i, j := 0, 0; i < len(expected) || j < len(actual); {
+		switch {
+		case j >= len(actual):
+			missing = append(missing, expected[i])
+			i++
+		case i >= len(expected):
+			extra = append(extra, actual[j])
+			j++
+		default:
+			switch result := o.compareColumns(kinds, columns, rows.Data[expected[i]], actual[j]); {
+			case result == 0:
+				i++
+				j++
+			case result < 0:
+				missing = append(missing, expected[i])
+				i++
+			default:
+				extra = append(extra, actual[j])
+				j++
+			}
+		}
+	}
+	for _, row := range missing {
+		diff.addMissing(rows.Data[row], limit)
+	}
+	for _, row := range extra {
+		diff.addExtra(row, limit)
+	}
+	for i := 0; i < len(missing) && i < len(extra); i++ {
+		for j, value := range rows.Data[missing[i]] {
+			if o.compareCell(kinds[j], value, extra[i][j]) != 0 {
+				diff.addChanged(missing[i], string(rows.Columns[j]), value, extra[i][j], limit)
+			}
+		}
+	}
+	return diff
+}
+
+func (r Rows) newDiff(other Rows) ResultDiff {
+	diff := ResultDiff{ExpectedRows: r.RowCount(), ActualRows: other.RowCount()}
+	for _, column := range r.Columns {
+		diff.Columns = append(diff.Columns, string(column))
+	}
+	return diff
+}
+
+func (d *ResultDiff) addMissing(row Row, limit int) {
+	if len(d.Missing) >= limit {
+		d.Truncated = true
+		return
+	}
+	d.Missing = append(d.Missing, row.strings())
+}
+
+func (d *ResultDiff) addExtra(row Row, limit int) {
+	if len(d.Extra) >= limit {
+		d.Truncated = true
+		return
+	}
+	d.Extra = append(d.Extra, row.strings())
+}
+
+func (d *ResultDiff) addChanged(row int, column string, expected, actual []byte, limit int) {
+	if len(d.Changed) >= limit {
+		d.Truncated = true
+		return
+	}
+	d.Changed = append(d.Changed, CellDiff{Row: row, Column: column, Expected: cellString(expected), Actual: cellString(actual)})
+}
+
+func (d ResultDiff) String() string {
+	if d.Expected != "" || d.Actual != "" {
+		return fmt.Sprintf("expected:\n%s\nactual:\n%s", d.Expected, d.Actual)
+	}
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "rows: expected %d, actual %d\n", d.ExpectedRows, d.ActualRows)
+	if d.OrderMismatch {
+		builder.WriteString("rows are the same but in different order\n")
+	}
+	header := table.Row{"diff"}
+	for _, column := range d.Columns {
+		header = append(header, column)
+	}
+	if len(d.Missing) != 0 || len(d.Extra) != 0 {
+		t := table.NewWriter()
+		t.AppendHeader(header)
+		for _, row := range d.Missing {
+			t.AppendRow(append(table.Row{"-"}, stringsRow(row)...))
+		}
+		for _, row := range d.Extra {
+			t.AppendRow(append(table.Row{"+"}, stringsRow(row)...))
+		}
+		builder.WriteString(t.Render())
+		builder.WriteString("\n")
+	}
+	if len(d.Changed) != 0 {
+		t := table.NewWriter()
+		t.AppendHeader(table.Row{"row", "column", "expected", "actual"})
+		for _, cell := range d.Changed {
+			t.AppendRow(table.Row{cell.Row, cell.Column, cell.Expected, cell.Actual})
+		}
+		builder.WriteString(t.Render())
+		builder.WriteString("\n")
+	}
+	if d.Truncated {
+		builder.WriteString("...(truncated)\n")
+	}
+	return builder.String()
+}
+
+func (r Row) strings() []string {
+	values := make([]string, 0, len(r))
+	for _, value := range r {
+		values = append(values, cellString(value))
+	}
+	return values
+}
+
+func cellString(value []byte) string {
+	if value == nil {
+		return "NULL"
+	}
+	return string(value)
+}
+
+func stringsRow(values []string) table.Row {
+	row := make(table.Row, 0, len(values))
+	for _, value := range values {
+		row = append(row, value)
+	}
+	return row
+}
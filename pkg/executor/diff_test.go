@@ -0,0 +1,50 @@
+package executor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func diffRows(data ...[]string) Rows {
+	return explainRows([]string{"id", "name"}, data...)
+}
+
+func TestMultisetRows_Diff(t *testing.T) {
+	expected := MultisetRows{Rows: diffRows([]string{"1", "a"}, []string{"2", "b"}, []string{"3", "c"})}
+	actual := diffRows([]string{"3", "c"}, []string{"2", "x"}, []string{"1", "a"}, []string{"4", "d"})
+
+	diff := Diff(expected, actual, 10)
+	assert.Equal(t, 3, diff.ExpectedRows)
+	assert.Equal(t, 4, diff.ActualRows)
+	assert.Equal(t, [][]string{{"2", "b"}}, diff.Missing)
+	assert.Equal(t, [][]string{{"2", "x"}, {"4", "d"}}, diff.Extra)
+	require.Len(t, diff.Changed, 1)
+	assert.Equal(t, CellDiff{Row: 1, Column: "name", Expected: "b", Actual: "x"}, diff.Changed[0])
+	assert.False(t, diff.Truncated)
+
+	unsorted := MultisetRows{Rows: diffRows([]string{"3", "c"}, []string{"1", "a"}, []string{"2", "b"})}
+	diff = Diff(unsorted, diffRows([]string{"1", "a"}, []string{"3", "c"}, []string{"2", "x"}), 10)
+	assert.Equal(t, []CellDiff{{Row: 2, Column: "name", Expected: "b", Actual: "x"}}, diff.Changed)
+
+	diff = Diff(expected, actual, 1)
+	assert.Len(t, diff.Extra, 1)
+	assert.True(t, diff.Truncated)
+	assert.Contains(t, diff.String(), "(truncated)")
+}
+
+func TestPrefixOrderedRows_Diff(t *testing.T) {
+	expected := PrefixOrderedRows{Rows: diffRows([]string{"1", "a"}, []string{"2", "b"}), Keys: []int{0}}
+	diff := Diff(expected, diffRows([]string{"2", "b"}, []string{"1", "a"}), 10)
+	assert.True(t, diff.OrderMismatch)
+	assert.Empty(t, diff.Missing)
+	assert.Empty(t, diff.Extra)
+}
+
+func TestRows_Diff(t *testing.T) {
+	diff := Diff(diffRows([]string{"1", "a"}, []string{"2", "b"}), diffRows([]string{"1", "b"}), 10)
+	assert.Equal(t, []CellDiff{{Row: 0, Column: "name", Expected: "a", Actual: "b"}}, diff.Changed)
+	assert.Equal(t, [][]string{{"2", "b"}}, diff.Missing)
+	assert.Empty(t, diff.Extra)
+}
@@ -0,0 +1,368 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package executor
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"os"
+	"strconv"
+)
+
+type (
+	// Digester folds a result into a ResultDigest while it streams, the order semantics are the same as
+	// MultisetRows (Ordered is false), PrefixOrderedRows (Ordered with Keys) and Rows (Ordered without Keys)
+	Digester struct {
+		Keys    []int
+		Ordered bool
+		Options CompareOptions
+		// Limit is the LIMIT of the query, results it may cut are compared by their spills or row counts
+		Limit *RowLimit
+		// SpillDir keeps the full result in a temporary file under it for the diff, empty means no spilling
+		SpillDir string
+	}
+
+	// ResultDigest is a Comparable which only keeps the digest of a result
+	ResultDigest struct {
+		Digester
+		Columns  Row
+		RowCount int
+		Sum      string
+		// SpillFile is the path of the spilled result, empty if it is not spilled
+		SpillFile string
+		typeNames []string
+	}
+
+	digestState struct {
+		kinds   []valueKind
+		keys    []int
+		ordered bool
+		options CompareOptions
+		rows    int
+		// sum is the order-insensitive sum of row hashes in the current group
+		sum      [4]uint64
+		groupKey []byte
+		outer    hash.Hash
+	}
+)
+
+// DigestStream consumes the stream and closes it
+func (d Digester) DigestStream(stream *RowStream) (digest ResultDigest, err error) {
+	digest = ResultDigest{Digester: d, Columns: stream.Columns, typeNames: stream.databaseTypes()}
+	state := d.newState(stream.Columns, digest.typeNames)
+
+	var spill *spillWriter
+	if d.SpillDir != "" {
+		spill, err = newSpillWriter(d.SpillDir, stream.Columns)
+		if err != nil {
+			stream.Close()
+			return
+		}
+		digest.SpillFile = spill.file.Name()
+		defer func() {
+			if closeErr := spill.close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				digest.Remove()
+			}
+		}()
+	}
+
+	for {
+		var row Row
+		row, err = stream.Next()
+		if err != nil || row == nil {
+			break
+		}
+		state.add(row)
+		if spill != nil {
+			if err = spill.write(row); err != nil {
+				stream.Close()
+				break
+			}
+		}
+	}
+	digest.RowCount, digest.Sum = state.rows, state.digest()
+	return
+}
+
+func (d Digester) newState(columns Row, typeNames []string) *digestState {
+	return &digestState{
+		kinds:   columnKinds(typeNames, len(columns)),
+		keys:    d.Keys,
+		ordered: d.Ordered,
+		options: d.Options,
+		outer:   sha256.New(),
+	}
+}
+
+func (s *digestState) add(row Row) {
+	rowHash := sha256.New()
+	for i, value := range row {
+		writeCell(rowHash, s.options.normalize(s.kinds[i], value))
+	}
+	sum := rowHash.Sum(nil)
+
+	if s.ordered {
+		groupKey := sum
+		if len(s.keys) != 0 {
+			keyHash := sha256.New()
+			for _, key := range s.keys {
+				writeCell(keyHash, s.options.normalize(s.kinds[key], row[key]))
+			}
+			groupKey = keyHash.Sum(nil)
+		}
+		if s.rows != 0 && string(groupKey) != string(s.groupKey) {
+			s.closeGroup()
+		}
+		s.groupKey = groupKey
+	}
+	for i := range s.sum {
+		s.sum[i] += binary.BigEndian.Uint64(sum[i*8:])
+	}
+	s.rows++
+}
+
+// closeGroup folds the sum of the current group into the order-sensitive hash
+func (s *digestState) closeGroup() {
+	var buf [8]byte
+	for i := range s.sum {
+		binary.BigEndian.PutUint64(buf[:], s.sum[i])
+		s.outer.Write(buf[:])
+	}
+	s.sum = [4]uint64{}
+}
+
+func (s *digestState) digest() string {
+	s.closeGroup()
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(s.rows))
+	s.outer.Write(buf[:])
+	return hex.EncodeToString(s.outer.Sum(nil))
+}
+
+// normalize returns the canonical form of a value, so that values equal under options have the same digest.
+// Approximate values are rounded to the significant digits of Epsilon, values within the tolerance
+// may still be rounded apart at the boundary, ResultDigest.Equal compares spilled results again for them.
+func (o CompareOptions) normalize(kind valueKind, value []byte) []byte {
+	if value == nil {
+		return nil
+	}
+	switch kind {
+	case kindExact:
+		if rat, ok := new(big.Rat).SetString(string(value)); ok {
+			return []byte(rat.RatString())
+		}
+	case kindApprox:
+		if f, err := strconv.ParseFloat(string(value), 64); err == nil {
+			digits := -1
+			if o.Epsilon > 0 {
+				digits = int(math.Max(1, math.Min(17, math.Floor(-math.Log10(o.Epsilon)))))
+			}
+			return []byte(strconv.FormatFloat(f, 'g', digits, 64))
+		}
+	case kindString:
+		return []byte(o.collate(string(value)))
+	}
+	return value
+}
+
+// writeCell writes a value prefixed by its length plus one, zero means NULL
+func writeCell(w io.Writer, value []byte) {
+	var buf [binary.MaxVarintLen64]byte
+	length := 0
+	if value != nil {
+		length = len(value) + 1
+	}
+	w.Write(buf[:binary.PutUvarint(buf[:], uint64(length))])
+	w.Write(value)
+}
+
+// Equal compares digests of results, other must be a ResultDigest
+func (d ResultDigest) Equal(other Comparable) bool {
+	otherDigest, ok := other.(ResultDigest)
+	if !ok || d.RowCount != otherDigest.RowCount || len(d.Columns) != len(otherDigest.Columns) {
+		return false
+	}
+	for i, column := range d.Columns {
+		if string(column) != string(otherDigest.Columns[i]) {
+			return false
+		}
+	}
+	spilled := d.SpillFile != "" && otherDigest.SpillFile != ""
+	if head, tail := d.Limit.cuts(d.RowCount); (head || tail) && !spilled {
+		// digests of rows tied at the boundaries of the limit may differ
+		return true
+	}
+	if d.Sum == otherDigest.Sum {
+		return true
+	}
+	// values within Epsilon may be normalized apart and rows tied at the limit may differ,
+	// so spilled results are compared again as rows
+	return spilled && d.equalSpills(otherDigest)
+}
+
+// equalSpills loads spilled results of both sides and compares them as rows, failures of loading are mismatches
+func (d ResultDigest) equalSpills(other ResultDigest) bool {
+	rows, err := d.Load()
+	if err != nil {
+		return false
+	}
+	otherRows, err := other.Load()
+	if err != nil {
+		return false
+	}
+	return d.comparable(rows).Equal(otherRows)
+}
+
+func (d ResultDigest) String() string {
+	return fmt.Sprintf("%d rows, digest: %s", d.RowCount, d.Sum)
+}
+
+// Diff loads spilled results of both sides, it only compares digests if any of them is not spilled
+func (d ResultDigest) Diff(other Comparable, limit int) ResultDiff {
+	otherDigest, ok := other.(ResultDigest)
+	if !ok || d.SpillFile == "" || otherDigest.SpillFile == "" {
+		return ResultDiff{Expected: d.String(), Actual: other.String()}
+	}
+	rows, err := d.Load()
+	if err != nil {
+		return ResultDiff{Expected: fmt.Sprintf("%s (%v)", d.String(), err), Actual: other.String()}
+	}
+	otherRows, err := otherDigest.Load()
+	if err != nil {
+		return ResultDiff{Expected: d.String(), Actual: fmt.Sprintf("%s (%v)", other.String(), err)}
+	}
+	return Diff(d.comparable(rows), otherRows, limit)
+}
+
+func (d ResultDigest) comparable(rows Rows) Comparable {
+	switch {
+	case !d.Ordered:
+		return MultisetRows{Rows: rows, Options: d.Options, Limit: d.Limit}
+	case len(d.Keys) != 0:
+		return PrefixOrderedRows{Rows: rows, Keys: d.Keys, Options: d.Options, Limit: d.Limit}
+	default:
+		return rows
+	}
+}
+
+// Load reads the spilled result
+func (d ResultDigest) Load() (rows Rows, err error) {
+	file, err := os.Open(d.SpillFile)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	rows.Columns, err = readSpilledRow(reader)
+	if err != nil {
+		return
+	}
+	rows.typeNames = d.typeNames
+	for {
+		var row Row
+		row, err = readSpilledRow(reader)
+		if err == io.EOF {
+			err = nil
+			return
+		}
+		if err != nil {
+			return
+		}
+		rows.Data = append(rows.Data, row)
+	}
+}
+
+// Remove deletes the spilled result if there is one
+func (d ResultDigest) Remove() error {
+	if d.SpillFile == "" {
+		return nil
+	}
+	return os.Remove(d.SpillFile)
+}
+
+type spillWriter struct {
+	file   *os.File
+	writer *bufio.Writer
+}
+
+func newSpillWriter(dir string, columns Row) (*spillWriter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	file, err := ioutil.TempFile(dir, "result-*.spill")
+	if err != nil {
+		return nil, err
+	}
+	spill := &spillWriter{file: file, writer: bufio.NewWriter(file)}
+	if err = spill.write(columns); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return spill, nil
+}
+
+// write encodes a row as the count of cells and cells in the form of writeCell
+func (w *spillWriter) write(row Row) error {
+	var buf [binary.MaxVarintLen64]byte
+	if _, err := w.writer.Write(buf[:binary.PutUvarint(buf[:], uint64(len(row)))]); err != nil {
+		return err
+	}
+	for _, value := range row {
+		writeCell(w.writer, value)
+	}
+	return nil
+}
+
+func (w *spillWriter) close() error {
+	err := w.writer.Flush()
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func readSpilledRow(reader *bufio.Reader) (Row, error) {
+	cells, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, err
+	}
+	row := make(Row, 0, cells)
+	for ; cells > 0; cells-- {
+		length, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if length == 0 {
+			row = append(row, nil)
+			continue
+		}
+		value := make([]byte, length-1)
+		if _, err = io.ReadFull(reader, value); err != nil {
+			return nil, err
+		}
+		row = append(row, value)
+	}
+	return row, nil
+}
@@ -0,0 +1,128 @@
+package executor
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func digestOf(digester Digester, kinds []valueKind, rows Rows) string {
+	state := digester.newState(rows.Columns, nil)
+	if kinds != nil {
+		state.kinds = kinds
+	}
+	for _, row := range rows.Data {
+		state.add(row)
+	}
+	return state.digest()
+}
+
+func TestDigester(t *testing.T) {
+	columns := []string{"k", "v"}
+	rows := explainRows(columns, []string{"1", "a"}, []string{"1", "b"}, []string{"2", "c"})
+	groupSwapped := explainRows(columns, []string{"1", "b"}, []string{"1", "a"}, []string{"2", "c"})
+	keySwapped := explainRows(columns, []string{"2", "c"}, []string{"1", "a"}, []string{"1", "b"})
+
+	multiset := Digester{}
+	assert.Equal(t, digestOf(multiset, nil, rows), digestOf(multiset, nil, groupSwapped))
+	assert.Equal(t, digestOf(multiset, nil, rows), digestOf(multiset, nil, keySwapped))
+
+	prefix := Digester{Keys: []int{0}, Ordered: true}
+	assert.Equal(t, digestOf(prefix, nil, rows), digestOf(prefix, nil, groupSwapped))
+	assert.NotEqual(t, digestOf(prefix, nil, rows), digestOf(prefix, nil, keySwapped))
+
+	positional := Digester{Ordered: true}
+	assert.NotEqual(t, digestOf(positional, nil, rows), digestOf(positional, nil, groupSwapped))
+
+	duplicated := explainRows(columns, []string{"1", "a"}, []string{"1", "a"})
+	assert.NotEqual(t, digestOf(multiset, nil, duplicated), digestOf(multiset, nil, explainRows(columns, []string{"1", "a"})))
+	withNull := explainRows(columns, []string{"1", "a"})
+	withNull.Data[0][1] = nil
+	assert.NotEqual(t, digestOf(multiset, nil, withNull), digestOf(multiset, nil, explainRows(columns, []string{"1", ""})))
+}
+
+func TestDigesterNormalize(t *testing.T) {
+	kinds := []valueKind{kindExact, kindApprox}
+	columns := []string{"d", "f"}
+	rows := explainRows(columns, []string{"1.50", "0.30000000000000004"})
+	other := explainRows(columns, []string{"1.5", "0.3"})
+
+	assert.NotEqual(t, digestOf(Digester{}, kinds, rows), digestOf(Digester{}, kinds, other))
+	tolerant := Digester{Options: CompareOptions{Epsilon: 1e-9}}
+	assert.Equal(t, digestOf(tolerant, kinds, rows), digestOf(tolerant, kinds, other))
+}
+
+func TestSpill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spill")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	rows := explainRows([]string{"id", "name"}, []string{"1", ""}, []string{"2", "b"})
+	rows.Data[1][1] = nil
+	spill, err := newSpillWriter(dir, rows.Columns)
+	require.Nil(t, err)
+	for _, row := range rows.Data {
+		require.Nil(t, spill.write(row))
+	}
+	require.Nil(t, spill.close())
+
+	digest := ResultDigest{SpillFile: spill.file.Name()}
+	loaded, err := digest.Load()
+	require.Nil(t, err)
+	assert.Equal(t, rows.Columns, loaded.Columns)
+	require.Len(t, loaded.Data, 2)
+	assert.Equal(t, []byte{}, loaded.Data[0][1])
+	assert.Nil(t, loaded.Data[1][1])
+
+	require.Nil(t, digest.Remove())
+	_, err = os.Stat(digest.SpillFile)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func digestRows(t *testing.T, digester Digester, rows Rows) ResultDigest {
+	stream := NewRowStreamFromRows(rows)
+	digest, err := digester.DigestStream(&stream)
+	require.Nil(t, err)
+	return digest
+}
+
+func TestResultDigestLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spill")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	columns := []string{"k", "v"}
+	rows := explainRows(columns, []string{"1", "a"}, []string{"2", "b"}, []string{"2", "c"})
+	tied := explainRows(columns, []string{"1", "a"}, []string{"2", "b"}, []string{"2", "d"})
+	changed := explainRows(columns, []string{"1", "x"}, []string{"2", "b"}, []string{"2", "c"})
+
+	limited := Digester{Keys: []int{0}, Ordered: true, Limit: &RowLimit{Count: 3}, SpillDir: dir}
+	assert.True(t, digestRows(t, limited, rows).Equal(digestRows(t, limited, tied)))
+	assert.False(t, digestRows(t, limited, rows).Equal(digestRows(t, limited, changed)))
+
+	// only row counts are compared without spills
+	limited.SpillDir = ""
+	assert.True(t, digestRows(t, limited, rows).Equal(digestRows(t, limited, changed)))
+
+	unlimited := Digester{Keys: []int{0}, Ordered: true}
+	assert.False(t, digestRows(t, unlimited, rows).Equal(digestRows(t, unlimited, tied)))
+}
+
+func TestResultDigestEpsilon(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spill")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// both values are within epsilon but rounded apart by normalize
+	rows := explainRows([]string{"f"}, []string{"1.0000000049999"})
+	other := explainRows([]string{"f"}, []string{"1.0000000050001"})
+	rows.typeNames, other.typeNames = []string{"DOUBLE"}, []string{"DOUBLE"}
+
+	tolerant := Digester{Options: CompareOptions{Epsilon: 1e-9}}
+	assert.False(t, digestRows(t, tolerant, rows).Equal(digestRows(t, tolerant, other)))
+	tolerant.SpillDir = dir
+	assert.True(t, digestRows(t, tolerant, rows).Equal(digestRows(t, tolerant, other)))
+}
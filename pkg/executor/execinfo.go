@@ -0,0 +1,143 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package executor
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExecutionInfo is the parsed `execution info` column of EXPLAIN ANALYZE
+type ExecutionInfo struct {
+	Time        time.Duration
+	Loops       int64
+	Concurrency int64
+	CopTasks    int64
+	RPCNum      int64
+	RPCTime     time.Duration
+	ProcKeys    int64
+	// ProcTime is the time coprocessors spent on processing
+	ProcTime time.Duration
+	// Raw keeps all items, nested items are flattened like `cop_task.num`
+	Raw map[string]string
+}
+
+var (
+	rpcNumKeys      = []string{"rpc num", "cop_task.rpc_num", "rpc_info.Cop.num_rpc"}
+	rpcTimeKeys     = []string{"rpc time", "cop_task.rpc_time", "rpc_info.Cop.total_time"}
+	procKeysKeys    = []string{"proc keys", "cop_task.proc_keys", "scan_detail.total_process_keys"}
+	procTimeKeys    = []string{"cop_task.tot_proc", "time_detail.total_process_time"}
+	concurrencyKeys = []string{"Concurrency", "concurrency", "PartialConcurrency"}
+
+	memoryUnits = map[string]float64{
+		"Bytes": 1,
+		"KB":    1 << 10,
+		"MB":    1 << 20,
+		"GB":    1 << 30,
+		"TB":    1 << 40,
+	}
+)
+
+// ParseExecutionInfo parses texts like `time:20.9ms, loops:2, cop_task: {num: 1, max: 1.5ms, proc_keys: 3}`,
+// unknown or malformed items are kept in Raw only
+func ParseExecutionInfo(str string) ExecutionInfo {
+	info := ExecutionInfo{Raw: make(map[string]string)}
+	parseInfoItems(str, "", info.Raw)
+	info.Time = info.duration("time")
+	info.Loops = info.integer("loops")
+	info.Concurrency = info.integer(concurrencyKeys...)
+	info.CopTasks = info.integer("cop_task.num")
+	info.RPCNum = info.integer(rpcNumKeys...)
+	info.RPCTime = info.duration(rpcTimeKeys...)
+	info.ProcKeys = info.integer(procKeysKeys...)
+	info.ProcTime = info.duration(procTimeKeys...)
+	return info
+}
+
+func parseInfoItems(str, prefix string, items map[string]string) {
+	for _, item := range splitTopLevel(str) {
+		sep := strings.Index(item, ":")
+		if sep < 0 {
+			continue
+		}
+		key, value := strings.TrimSpace(item[:sep]), strings.TrimSpace(item[sep+1:])
+		if strings.HasPrefix(value, "{") && strings.HasSuffix(value, "}") {
+			parseInfoItems(value[1:len(value)-1], prefix+key+".", items)
+			continue
+		}
+		items[prefix+key] = value
+	}
+}
+
+// splitTopLevel splits str by commas out of braces and brackets
+func splitTopLevel(str string) (items []string) {
+	depth, start := 0, 0
+	for i, c := range str {
+		switch c {
+		case '{', '[', '(':
+			depth++
+		case '}', ']', ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				items = append(items, str[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if strings.TrimSpace(str[start:]) != "" {
+		items = append(items, str[start:])
+	}
+	return
+}
+
+func (info ExecutionInfo) duration(keys ...string) time.Duration {
+	for _, key := range keys {
+		if value, ok := info.Raw[key]; ok {
+			if d, err := time.ParseDuration(value); err == nil {
+				return d
+			}
+		}
+	}
+	return 0
+}
+
+func (info ExecutionInfo) integer(keys ...string) int64 {
+	for _, key := range keys {
+		if value, ok := info.Raw[key]; ok {
+			if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+				return i
+			}
+		}
+	}
+	return 0
+}
+
+// ParseMemory parses the `memory` and `disk` columns like `1.22 KB`, it returns -1 for `N/A` or malformed values
+func ParseMemory(str string) int64 {
+	fields := strings.Fields(str)
+	if len(fields) != 2 {
+		return -1
+	}
+	unit, ok := memoryUnits[fields[1]]
+	if !ok {
+		return -1
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return -1
+	}
+	return int64(value * unit)
+}
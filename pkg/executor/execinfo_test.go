@@ -0,0 +1,41 @@
+package executor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseExecutionInfo(t *testing.T) {
+	info := ParseExecutionInfo("time:20.912442ms, loops:2, rpc num: 1, rpc time:20.926766ms, proc keys:4")
+	require.Equal(t, 20912442*time.Nanosecond, info.Time)
+	require.Equal(t, int64(2), info.Loops)
+	require.Equal(t, int64(1), info.RPCNum)
+	require.Equal(t, 20926766*time.Nanosecond, info.RPCTime)
+	require.Equal(t, int64(4), info.ProcKeys)
+
+	info = ParseExecutionInfo("time:1.62ms, loops:2, cop_task: {num: 3, max: 1.5ms, proc_keys: 30, rpc_num: 3, rpc_time: 4.4ms, copr_cache_hit_ratio: 0.00}, tikv_task:{time:0s, loops:1}")
+	require.Equal(t, 1620*time.Microsecond, info.Time)
+	require.Equal(t, int64(3), info.CopTasks)
+	require.Equal(t, int64(3), info.RPCNum)
+	require.Equal(t, 4400*time.Microsecond, info.RPCTime)
+	require.Equal(t, int64(30), info.ProcKeys)
+	require.Equal(t, "0s", info.Raw["tikv_task.time"])
+
+	info = ParseExecutionInfo("time:1.2ms, loops:2, cop_task: {num: 1, max: 1ms, proc_keys: 3, tot_proc: 800µs, tot_wait: 100µs}")
+	require.Equal(t, 800*time.Microsecond, info.ProcTime)
+	info = ParseExecutionInfo("time:1.2ms, loops:2, time_detail: {total_process_time: 1.5ms, total_wait_time: 100µs}")
+	require.Equal(t, 1500*time.Microsecond, info.ProcTime)
+
+	info = ParseExecutionInfo("time:5.143791993s, loops:6, Concurrency:5, probe collision:0, build:32.444µs")
+	require.Equal(t, int64(5), info.Concurrency)
+	require.Equal(t, "32.444µs", info.Raw["build"])
+}
+
+func TestParseMemory(t *testing.T) {
+	require.Equal(t, int64(-1), ParseMemory("N/A"))
+	require.Equal(t, int64(0), ParseMemory("0 Bytes"))
+	require.Equal(t, int64(1249), ParseMemory("1.22 KB"))
+	require.Equal(t, int64(35127296), ParseMemory("33.5 MB"))
+}
@@ -14,9 +14,11 @@
 package executor
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -29,11 +31,17 @@ type (
 		Dsn() string
 		Executor() Executor
 		Transaction() (Transaction, error)
+		TransactionContext(ctx context.Context) (Transaction, error)
+		// Conn returns an executor running all statements on a dedicated connection
+		Conn(ctx context.Context) (Conn, error)
+		// SessionVariables are set on each connection of the pool
+		SessionVariables() map[string]string
 	}
 
 	RawExecutor interface {
-		Query(query string, args ...interface{}) (*sql.Rows, error)
-		Exec(query string, args ...interface{}) (sql.Result, error)
+		QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+		ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+		PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
 	}
 
 	RawTransaction interface {
@@ -45,11 +53,17 @@ type (
 	Executor interface {
 		Dsn() string
 		Query(query string) (Rows, error)
+		QueryContext(ctx context.Context, query string) (Rows, error)
 		QueryStream(query string) (RowStream, error)
+		QueryStreamContext(ctx context.Context, query string) (RowStream, error)
 		Exec(query string) (Result, error)
+		ExecContext(ctx context.Context, query string) (Result, error)
+		Prepare(query string) (PreparedStatement, error)
 		GetHints(query string) (Hints, error)
 		Explain(query string) (Rows, []error, error)
+		ExplainContext(ctx context.Context, query string) (Rows, []error, error)
 		ExplainAnalyze(query string) (Rows, []error, error)
+		ExplainAnalyzeContext(ctx context.Context, query string) (Rows, []error, error)
 	}
 
 	Transaction interface {
@@ -58,30 +72,58 @@ type (
 		Rollback() error
 	}
 
+	// Conn must be closed to return the connection to the pool
+	Conn interface {
+		Executor
+		Close() error
+	}
+
 	PoolOptions struct {
 		MaxOpenConns   uint `json:"max_open_conns"`
 		MaxIdleConns   uint `json:"max_idle_conns"`
 		MaxLifeSeconds uint `json:"max_life_seconds"`
+		// SessionVariables are set on each new connection, like `sql_mode` or `tidb_opt_*` knobs
+		SessionVariables map[string]string `json:"session_variables,omitempty"`
 	}
 
 	PoolImpl struct {
-		dsn string
-		db  *sql.DB
+		dsn              string
+		db               *sql.DB
+		sessionVariables map[string]string
+		flavor           *serverFlavor
 	}
 
 	ExecutorImpl struct {
 		dsn  string
 		exec RawExecutor
+		// db issues `KILL QUERY` for interrupted statements
+		db *sql.DB
+		// pinned means exec always runs on the same connection, like a transaction
+		pinned     bool
+		connIDOnce sync.Once
+		connID     uint64
+		connIDErr  error
+		// flavor is shared by executors of the pool
+		flavor *serverFlavor
 	}
 
 	TransactionImpl struct {
 		ExecutorImpl
 		tx RawTransaction
 	}
+
+	ConnImpl struct {
+		ExecutorImpl
+		conn *sql.Conn
+	}
 )
 
 func NewPool(dsn string, options *PoolOptions) (pool Pool, err error) {
-	db, err := sql.Open("mysql", dsn)
+	sessionDsn, err := withSessionVariables(dsn, options.SessionVariables)
+	if err != nil {
+		return
+	}
+	db, err := sql.Open("mysql", sessionDsn)
 	if err != nil {
 		return
 	}
@@ -99,8 +141,10 @@ func NewPool(dsn string, options *PoolOptions) (pool Pool, err error) {
 	}
 
 	pool = &PoolImpl{
-		dsn: dsn,
-		db:  db,
+		dsn:              dsn,
+		db:               db,
+		sessionVariables: options.SessionVariables,
+		flavor:           &serverFlavor{},
 	}
 	return pool, err
 }
@@ -110,26 +154,62 @@ func (e *ExecutorImpl) Dsn() string {
 }
 
 func (e *ExecutorImpl) Query(query string) (rows Rows, err error) {
-	data, err := e.exec.Query(query)
+	return e.QueryContext(context.Background(), query)
+}
+
+func (e *ExecutorImpl) QueryContext(ctx context.Context, query string) (rows Rows, err error) {
+	exec, stmtCtx, release, err := e.statement(ctx)
+	if err != nil {
+		return
+	}
+	defer release()
+	data, err := exec.QueryContext(stmtCtx, query)
 	if err != nil {
+		err = interrupted(ctx, query, err)
 		return
 	}
 	rows, err = NewRows(data)
+	err = interrupted(ctx, query, err)
 	return
 }
 
 func (e *ExecutorImpl) QueryStream(query string) (stream RowStream, err error) {
-	data, err := e.exec.Query(query)
+	return e.QueryStreamContext(context.Background(), query)
+}
+
+func (e *ExecutorImpl) QueryStreamContext(ctx context.Context, query string) (stream RowStream, err error) {
+	exec, stmtCtx, release, err := e.statement(ctx)
 	if err != nil {
 		return
 	}
+	data, err := exec.QueryContext(stmtCtx, query)
+	if err != nil {
+		release()
+		err = interrupted(ctx, query, err)
+		return
+	}
 	stream, err = NewRowStream(data)
+	if err != nil {
+		release()
+		return
+	}
+	stream.ctx, stream.query, stream.release = ctx, query, release
 	return
 }
 
 func (e *ExecutorImpl) Exec(query string) (result Result, err error) {
-	data, err := e.exec.Exec(query)
+	return e.ExecContext(context.Background(), query)
+}
+
+func (e *ExecutorImpl) ExecContext(ctx context.Context, query string) (result Result, err error) {
+	exec, stmtCtx, release, err := e.statement(ctx)
+	if err != nil {
+		return
+	}
+	defer release()
+	data, err := exec.ExecContext(stmtCtx, query)
 	if err != nil {
+		err = interrupted(ctx, query, err)
 		return
 	}
 	result, err = NewResult(data)
@@ -139,7 +219,7 @@ func (e *ExecutorImpl) Exec(query string) (result Result, err error) {
 /// GetHints would query plan out of range warnings
 func (e *ExecutorImpl) GetHints(query string) (hints Hints, err error) {
 	explanation := fmt.Sprintf("explain format = 'hint' %s", query)
-	rawRows, err := e.exec.Query(explanation)
+	rawRows, err := e.exec.QueryContext(context.Background(), explanation)
 	if err != nil {
 		return
 	}
@@ -161,27 +241,52 @@ func (e *ExecutorImpl) GetHints(query string) (hints Hints, err error) {
 }
 
 func (e *ExecutorImpl) Explain(query string) (rows Rows, warnings []error, err error) {
-	rows, err = e.Query(fmt.Sprintf("EXPLAIN %s", query))
-	if err != nil {
-		err = fmt.Errorf("explain error: %v", err)
-		return
-	}
-	warnings, err = e.queryWarnings()
-	return
+	return e.ExplainContext(context.Background(), query)
+}
+
+// ExplainContext explains the query in the tree format on MySQL since 8.0.16, its tabular format has no operator tree
+func (e *ExecutorImpl) ExplainContext(ctx context.Context, query string) (rows Rows, warnings []error, err error) {
+	return e.explain(ctx, "EXPLAIN", query)
 }
 
 func (e *ExecutorImpl) ExplainAnalyze(query string) (rows Rows, warnings []error, err error) {
-	rows, err = e.Query(fmt.Sprintf("EXPLAIN ANALYZE %s", query))
+	return e.ExplainAnalyzeContext(context.Background(), query)
+}
+
+func (e *ExecutorImpl) ExplainAnalyzeContext(ctx context.Context, query string) (rows Rows, warnings []error, err error) {
+	return e.explain(ctx, "EXPLAIN ANALYZE", query)
+}
+
+// explain runs the statement and `SHOW WARNINGS` on the same connection, so that warnings belong to the statement
+func (e *ExecutorImpl) explain(ctx context.Context, verb, query string) (rows Rows, warnings []error, err error) {
+	statement := fmt.Sprintf("%s %s", verb, query)
+	exec := e
+	if !e.pinned && e.db != nil {
+		var conn *sql.Conn
+		if conn, err = e.db.Conn(ctx); err != nil {
+			err = interrupted(ctx, statement, err)
+			return
+		}
+		defer conn.Close()
+		exec = &ExecutorImpl{dsn: e.dsn, exec: conn, db: e.db, pinned: true, flavor: e.flavor}
+	}
+	if verb == "EXPLAIN" && exec.flavor.explainsTree(ctx, exec.exec) {
+		statement = fmt.Sprintf("EXPLAIN FORMAT=TREE %s", query)
+	}
+	rows, err = exec.QueryContext(ctx, statement)
 	if err != nil {
-		err = fmt.Errorf("explain error: %v", err)
+		if IsTimeout(err) {
+			return
+		}
+		err = fmt.Errorf("explain error: %w", err)
 		return
 	}
-	warnings, err = e.queryWarnings()
+	warnings, err = exec.queryWarnings()
 	return
 }
 
 func (e *ExecutorImpl) queryWarnings() (warnings []error, err error) {
-	data, err := e.exec.Query("SHOW WARNINGS;")
+	data, err := e.exec.QueryContext(context.Background(), "SHOW WARNINGS;")
 	if err != nil {
 		return
 	}
@@ -211,15 +316,36 @@ func (t *TransactionImpl) Rollback() error {
 	return t.tx.Rollback()
 }
 
+func (c *ConnImpl) Close() error {
+	return c.conn.Close()
+}
+
 func (p *PoolImpl) Dsn() string {
 	return p.dsn
 }
 
+func (p *PoolImpl) SessionVariables() map[string]string {
+	return p.sessionVariables
+}
+
 func (p *PoolImpl) Executor() Executor {
-	return &ExecutorImpl{exec: p.db, dsn: p.dsn}
+	return &ExecutorImpl{exec: p.db, dsn: p.dsn, db: p.db, flavor: p.flavor}
 }
 
 func (p *PoolImpl) Transaction() (Transaction, error) {
-	tx, err := p.db.Begin()
-	return &TransactionImpl{ExecutorImpl: ExecutorImpl{exec: tx, dsn: p.dsn}, tx: tx}, err
+	return p.TransactionContext(context.Background())
+}
+
+// TransactionContext begins a transaction, which would be rolled back once ctx is done
+func (p *PoolImpl) TransactionContext(ctx context.Context) (Transaction, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	return &TransactionImpl{ExecutorImpl: ExecutorImpl{exec: tx, dsn: p.dsn, db: p.db, pinned: true, flavor: p.flavor}, tx: tx}, err
+}
+
+func (p *PoolImpl) Conn(ctx context.Context) (Conn, error) {
+	conn, err := p.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &ConnImpl{ExecutorImpl: ExecutorImpl{exec: conn, dsn: p.dsn, db: p.db, pinned: true, flavor: p.flavor}, conn: conn}, nil
 }
@@ -1,23 +1,44 @@
 package executor
 
 import (
+	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
-	"unicode/utf8"
+	"time"
 
 	"github.com/chaos-mesh/horoscope/pkg/utils"
 )
 
-var operatorRegex = regexp.MustCompile(`[a-zA-Z]+`)
+type explainLayout uint8
+
+const (
+	// layoutTable is the tabular output of TiDB, columns are mapped by name
+	layoutTable explainLayout = iota
+	// layoutTree is the single column tree output of MySQL 8, `EXPLAIN ANALYZE` or `EXPLAIN FORMAT=TREE`
+	layoutTree
+)
+
+var (
+	// lines like `-> Table scan on t1  (cost=1.15 rows=9) (actual time=0.034..0.049 rows=9 loops=1)`
+	treeLineRegex   = regexp.MustCompile(`^(\s*)-> (.*?)(?:\s+\(cost=([\d.e+]+) rows=([\d.e+]+)\))?(?:\s+\(actual time=([\d.]+)\.\.([\d.]+) rows=([\d.e+]+) loops=(\d+)\))?(?:\s+\(never executed\))?$`)
+	treeTableRegex  = regexp.MustCompile(`\bon (\S+)`)
+	treeOpStopRegex = regexp.MustCompile(`:| on | using |\(`)
+)
 
 type ExplainAnalyzeInfo struct {
-	Op      string
-	EstRows float64
-	ActRows float64
-	OpInfo  string
-	Items   []*ExplainAnalyzeInfo
-	parent  *ExplainAnalyzeInfo
+	Op string
+	// ID is the operator id of TiDB, like `45` of `HashJoin_45`
+	ID       string
+	EstRows  float64
+	ActRows  float64
+	OpInfo   string
+	ExecInfo ExecutionInfo
+	// Memory and Disk are in bytes, -1 means N/A
+	Memory int64
+	Disk   int64
+	Items  []*ExplainAnalyzeInfo
+	parent *ExplainAnalyzeInfo
 }
 
 type CardinalityInfo struct {
@@ -25,51 +46,289 @@ type CardinalityInfo struct {
 	QError float64
 }
 
-func NewExplainAnalyzeInfo(data Rows) *ExplainAnalyzeInfo {
-	if !data.Columns[0:7].Equal([][]byte{[]byte("id"), []byte("estRows"), []byte("actRows"), []byte("task"), []byte("access object"), []byte("execution info"), []byte("operator info")}) {
-		return nil
+// NewExplainAnalyzeInfo builds the operator tree from EXPLAIN ANALYZE, the layout is detected from the header
+func NewExplainAnalyzeInfo(data Rows) (*ExplainAnalyzeInfo, error) {
+	layout, err := detectLayout(data)
+	if err != nil {
+		return nil, err
+	}
+	switch layout {
+	case layoutTree:
+		return newTreeAnalyzeInfo(data)
+	default:
+		return newTableAnalyzeInfo(data)
+	}
+}
+
+func detectLayout(data Rows) (explainLayout, error) {
+	_, hasID := data.columnIndex("id")
+	_, hasEstRows := data.columnIndex("estRows")
+	_, hasCount := data.columnIndex("count")
+	if hasID && (hasEstRows || hasCount) {
+		return layoutTable, nil
 	}
-	var ei, lastInfo *ExplainAnalyzeInfo
-	lastLevel := 0
-	for index, row := range data.Data {
-		op, level := parseAnalyzeID(string(row[0]))
-		estRows := parseFloatColumn(string(row[1]))
-		actRows := parseFloatColumn(string(row[2]))
-		opInfo := string(row[6])
+	if data.ColumnNums() == 1 && data.RowCount() > 0 && strings.HasPrefix(strings.TrimSpace(string(data.Data[0][0])), "->") {
+		return layoutTree, nil
+	}
+	return 0, fmt.Errorf("unsupported explain layout: %s", data.Columns.ToTableRow())
+}
+
+func newTableAnalyzeInfo(data Rows) (*ExplainAnalyzeInfo, error) {
+	idIdx, _ := data.columnIndex("id")
+	estRowsIdx, ok := data.columnIndex("estRows")
+	if !ok {
+		// TiDB 3.x
+		estRowsIdx, _ = data.columnIndex("count")
+	}
+	actRowsIdx, hasActRows := data.columnIndex("actRows")
+	execInfoIdx, hasExecInfo := data.columnIndex("execution info")
+	opInfoIdx, hasOpInfo := data.columnIndex("operator info")
+	memoryIdx, hasMemory := data.columnIndex("memory")
+	diskIdx, hasDisk := data.columnIndex("disk")
+	if !hasActRows && !hasExecInfo {
+		return nil, fmt.Errorf("cannot find actual rows in explanation: %s", data.Columns.ToTableRow())
+	}
+
+	var ei *ExplainAnalyzeInfo
+	var stack []*ExplainAnalyzeInfo
+	for _, row := range data.Data {
+		node, level, err := parseOperatorID(string(row[idIdx]))
+		if err != nil {
+			return nil, err
+		}
 		cur := &ExplainAnalyzeInfo{
-			Op:      op,
-			EstRows: estRows,
-			ActRows: actRows,
-			OpInfo:  opInfo,
+			Op:     node.Op,
+			ID:     node.ID,
+			Memory: -1,
+			Disk:   -1,
+		}
+		if cur.EstRows, err = parseFloatColumn(string(row[estRowsIdx])); err != nil {
+			return nil, fmt.Errorf("invalid estRows of %s: %v", row[idIdx], err)
+		}
+		if hasExecInfo {
+			cur.ExecInfo = ParseExecutionInfo(string(row[execInfoIdx]))
 		}
-		if index == 0 {
-			ei, lastInfo = cur, cur
+		if hasActRows {
+			cur.ActRows, err = parseFloatColumn(string(row[actRowsIdx]))
 		} else {
-			levelDiff := level - lastLevel
-			for i := 0; i < -levelDiff+1; i++ {
-				lastInfo = lastInfo.parent
+			// TiDB 3.x puts actual rows in execution info
+			cur.ActRows, err = parseFloatColumn(cur.ExecInfo.Raw["rows"])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid actRows of %s: %v", row[idIdx], err)
+		}
+		if hasOpInfo {
+			cur.OpInfo = string(row[opInfoIdx])
+		}
+		if hasMemory {
+			cur.Memory = ParseMemory(string(row[memoryIdx]))
+		}
+		if hasDisk {
+			cur.Disk = ParseMemory(string(row[diskIdx]))
+		}
+
+		if stack, err = cur.attach(stack, level); err != nil {
+			return nil, err
+		}
+		if level == 0 {
+			ei = cur
+		}
+	}
+	if ei == nil {
+		return nil, fmt.Errorf("empty explanation")
+	}
+	return ei, nil
+}
+
+// newTreeAnalyzeInfo parses the tree of MySQL 8 by walkTree
+func newTreeAnalyzeInfo(data Rows) (ei *ExplainAnalyzeInfo, err error) {
+	var infos []*ExplainAnalyzeInfo
+	err = walkTree(data, func(line treeLine, parent int) (err error) {
+		cur := &ExplainAnalyzeInfo{
+			Op:     treeOperator(line.desc),
+			OpInfo: line.desc,
+			Memory: -1,
+			Disk:   -1,
+		}
+		if line.rows != "" {
+			if cur.EstRows, err = parseFloatColumn(line.rows); err != nil {
+				return fmt.Errorf("invalid rows of %s: %v", line.desc, err)
 			}
-			lastInfo.Items = append(lastInfo.Items, cur)
-			cur.parent = lastInfo
 		}
-		lastInfo = cur
-		lastLevel = level
+		if line.actRows != "" {
+			last, _ := strconv.ParseFloat(line.last, 64)
+			rows, _ := strconv.ParseFloat(line.actRows, 64)
+			loops, _ := strconv.ParseInt(line.loops, 10, 64)
+			// actual rows and time of MySQL are averaged per loop
+			cur.ActRows = rows * float64(loops)
+			cur.ExecInfo = ExecutionInfo{
+				Time:  time.Duration(last * float64(loops) * float64(time.Millisecond)),
+				Loops: loops,
+				Raw:   map[string]string{"actual time": line.first + ".." + line.last, "rows": line.actRows, "loops": line.loops},
+			}
+		}
+		if parent >= 0 {
+			cur.parent = infos[parent]
+			cur.parent.Items = append(cur.parent.Items, cur)
+		}
+		infos = append(infos, cur)
+		return
+	})
+	if err != nil {
+		return nil, err
 	}
-	return ei
+	return infos[0], nil
 }
 
-func parseAnalyzeID(str string) (op string, level int) {
-	level = utf8.RuneCountInString(str[0:strings.IndexAny(str, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")]) / 2
-	op = operatorRegex.FindStringSubmatch(str)[0]
+// treeLine is an operator of the tree of MySQL 8, fields are empty if the server omits them
+type treeLine struct {
+	desc string
+	// cost and rows are estimated
+	cost string
+	rows string
+	// first and last are the actual time in milliseconds of the first and all rows, actRows and them are per loop
+	first   string
+	last    string
+	actRows string
+	loops   string
+}
+
+// walkTree parses the tree of MySQL 8, `EXPLAIN ANALYZE` or `EXPLAIN FORMAT=TREE`, each level is indented by four
+// spaces. visit is called on operators in pre-order with the index of the parent in the visited ones, -1 for the root
+func walkTree(data Rows, visit func(line treeLine, parent int) error) error {
+	// stack holds the index of the last operator of each level
+	var stack []int
+	count := 0
+	for _, row := range data.Data {
+		for _, text := range strings.Split(string(row[0]), "\n") {
+			if strings.TrimSpace(text) == "" {
+				continue
+			}
+			matches := treeLineRegex.FindStringSubmatch(text)
+			if matches == nil {
+				return fmt.Errorf("invalid explain tree line: %s", text)
+			}
+			line := treeLine{desc: matches[2], cost: matches[3], rows: matches[4], first: matches[5], last: matches[6], actRows: matches[7], loops: matches[8]}
+			level := len(matches[1]) / 4
+			if level > len(stack) || (level == 0 && len(stack) > 0) {
+				return fmt.Errorf("operator %s is not well indented", line.desc)
+			}
+			stack = stack[:level]
+			parent := -1
+			if level > 0 {
+				parent = stack[level-1]
+			}
+			if err := visit(line, parent); err != nil {
+				return err
+			}
+			stack = append(stack, count)
+			count++
+		}
+	}
+	if count == 0 {
+		return fmt.Errorf("empty explanation")
+	}
+	return nil
+}
+
+// treeOperator names operators of MySQL like TiDB, so that `Selection` and `*Join` are recognized
+func treeOperator(desc string) string {
+	lower := strings.ToLower(desc)
+	switch {
+	case strings.HasPrefix(lower, "filter"):
+		return "Selection"
+	case strings.Contains(lower, "hash join"):
+		return "HashJoin"
+	case strings.Contains(lower, "nested loop"):
+		return "NestedLoopJoin"
+	}
+	head := desc
+	if loc := treeOpStopRegex.FindStringIndex(desc); loc != nil {
+		head = desc[:loc[0]]
+	}
+	var op strings.Builder
+	for _, word := range strings.Fields(head) {
+		op.WriteString(strings.ToUpper(word[:1]) + word[1:])
+	}
+	return op.String()
+}
+
+// attach appends ei to its parent in stack, which holds the last operator of each level
+func (ei *ExplainAnalyzeInfo) attach(stack []*ExplainAnalyzeInfo, level int) ([]*ExplainAnalyzeInfo, error) {
+	if level > len(stack) || (level == 0 && len(stack) > 0) {
+		return nil, fmt.Errorf("operator %s%s is not well indented", ei.Op, ei.ID)
+	}
+	stack = stack[:level]
+	if level > 0 {
+		ei.parent = stack[level-1]
+		ei.parent.Items = append(ei.parent.Items, ei)
+	}
+	return append(stack, ei), nil
+}
+
+// ExclusiveTime is the wall time spent in the operator itself, children are executed concurrently
+// so it is only an approximation
+func (ei *ExplainAnalyzeInfo) ExclusiveTime() time.Duration {
+	exclusive := ei.ExecInfo.Time
+	for _, item := range ei.Items {
+		exclusive -= item.ExecInfo.Time
+	}
+	if exclusive < 0 {
+		return 0
+	}
+	return exclusive
+}
+
+// Walk visits all operators in pre-order
+func (ei *ExplainAnalyzeInfo) Walk(fn func(info *ExplainAnalyzeInfo)) {
+	if ei == nil {
+		return
+	}
+	fn(ei)
+	for _, item := range ei.Items {
+		item.Walk(fn)
+	}
+}
+
+// ServerStats are resources spent by the server on a statement
+type ServerStats struct {
+	// Latency is the execution time of the root operator
+	Latency       time.Duration
+	ProcessedKeys int64
+	// Memory is the sum of memory tracked by operators in bytes
+	Memory int64
+	// CPUTime is the time coprocessors spent on processing
+	CPUTime time.Duration
+}
+
+// ServerStats sums up resources of all operators. Readers of root tasks summarize processed keys and time
+// of their coprocessor tasks, which are reported again by the scans of the tasks, so scans under a reader
+// with these stats are not counted
+func (ei *ExplainAnalyzeInfo) ServerStats() (stats ServerStats) {
+	stats.Latency = ei.ExecInfo.Time
+	ei.Walk(func(info *ExplainAnalyzeInfo) {
+		if info.Memory > 0 {
+			stats.Memory += info.Memory
+		}
+	})
+	ei.addProcessed(&stats)
 	return
 }
 
-func parseFloatColumn(str string) float64 {
-	f, err := strconv.ParseFloat(str, 64)
-	if err != nil {
-		panic(err)
+// addProcessed adds processed keys and time of the first operators reporting them on each path
+func (ei *ExplainAnalyzeInfo) addProcessed(stats *ServerStats) {
+	if ei.ExecInfo.ProcKeys > 0 || ei.ExecInfo.ProcTime > 0 {
+		stats.ProcessedKeys += ei.ExecInfo.ProcKeys
+		stats.CPUTime += ei.ExecInfo.ProcTime
+		return
+	}
+	for _, item := range ei.Items {
+		item.addProcessed(stats)
 	}
-	return f
+}
+
+func parseFloatColumn(str string) (float64, error) {
+	return strconv.ParseFloat(strings.TrimSpace(str), 64)
 }
 
 func CollectEstAndActRows(ei *ExplainAnalyzeInfo) []*CardinalityInfo {
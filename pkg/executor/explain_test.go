@@ -2,6 +2,7 @@ package executor
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -17,10 +18,89 @@ func TestNewExplainAnalyzeInfo(t *testing.T) {
 			[][]byte{[]byte("  └─HashJoin_74(Probe)"), []byte("76450.66"), []byte("405"), []byte("root"), []byte(""), []byte("time:5.143556164s, loops:6, Concurrency:5, probe collision:0, build:850.482µs"), []byte("")},
 		},
 	}
-	got := NewExplainAnalyzeInfo(rows)
+	got, err := NewExplainAnalyzeInfo(rows)
+	require.Nil(t, err)
 	require.Equal(t, got.Op, "HashAgg")
 	require.Equal(t, got.Items[0].Op, "HashJoin")
 	require.Equal(t, got.Items[0].Items[0].Op, "TableReader")
 	require.Equal(t, got.Items[0].Items[0].Items[0].Op, "TableFullScan")
 	require.Equal(t, got.Items[0].Items[1].Op, "HashJoin")
 }
+
+func TestNewExplainAnalyzeInfoLayouts(t *testing.T) {
+	tidb3 := explainRows([]string{"id", "count", "task", "operator info", "execution info", "memory"},
+		[]string{"HashLeftJoin_7", "12487.50", "root", "inner join, equal:[eq(test.t.a, test.s.a)]", "time:3.1ms, loops:2, rows:3", "1.22 KB"},
+		[]string{"├─TableReader_10", "9990.00", "root", "data:Selection_9", "time:1.2ms, loops:2, rows:3", "N/A"},
+		[]string{"└─TableReader_13", "9990.00", "root", "data:Selection_12", "time:1.1ms, loops:2, rows:3", "N/A"},
+	)
+	got, err := NewExplainAnalyzeInfo(tidb3)
+	require.Nil(t, err)
+	require.Equal(t, "HashLeftJoin", got.Op)
+	require.Equal(t, "7", got.ID)
+	require.Equal(t, float64(3), got.ActRows)
+	require.Equal(t, int64(1249), got.Memory)
+	require.Equal(t, int64(-1), got.Disk)
+	require.Len(t, got.Items, 2)
+
+	mysql := explainRows([]string{"EXPLAIN"}, []string{
+		"-> Nested loop inner join  (cost=4.95 rows=9) (actual time=0.153..0.200 rows=9 loops=1)\n" +
+			"    -> Filter: (t1.a is not null)  (cost=1.15 rows=9) (actual time=0.035..0.054 rows=9 loops=1)\n" +
+			"        -> Table scan on t1  (cost=1.15 rows=9) (actual time=0.034..0.049 rows=9 loops=1)\n" +
+			"    -> Index lookup on t2 using a (a=t1.a)  (cost=0.26 rows=1) (actual time=0.013..0.015 rows=2 loops=9)\n",
+	})
+	got, err = NewExplainAnalyzeInfo(mysql)
+	require.Nil(t, err)
+	require.Equal(t, "NestedLoopJoin", got.Op)
+	require.Equal(t, float64(9), got.EstRows)
+	require.Equal(t, "Selection", got.Items[0].Op)
+	require.Equal(t, "TableScan", got.Items[0].Items[0].Op)
+	require.Equal(t, "IndexLookup", got.Items[1].Op)
+	require.Equal(t, float64(18), got.Items[1].ActRows)
+	require.Equal(t, int64(9), got.Items[1].ExecInfo.Loops)
+
+	tree, err := NewPlanTree(mysql)
+	require.Nil(t, err)
+	require.Equal(t, 4.95, tree.Root.EstCost)
+	require.Equal(t, "table:t1", tree.Root.Children[0].Children[0].AccessObject)
+
+	_, err = NewExplainAnalyzeInfo(explainRows([]string{"id", "select_type", "table"}, []string{"1", "SIMPLE", "t"}))
+	require.NotNil(t, err)
+}
+
+func TestExplainAnalyzeInfo_ServerStats(t *testing.T) {
+	rows := explainRows([]string{"id", "estRows", "actRows", "task", "access object", "execution info", "operator info", "memory", "disk"},
+		[]string{"HashJoin_7", "12487.50", "3", "root", "", "time:3.1ms, loops:2", "inner join", "1 KB", "0 Bytes"},
+		[]string{"├─TableReader_10", "9990.00", "3", "root", "", "time:1.2ms, loops:2, cop_task: {num: 1, max: 1ms, proc_keys: 10, tot_proc: 800µs}", "data:TableFullScan_9", "512 Bytes", "N/A"},
+		[]string{"│ └─TableFullScan_9", "9990.00", "10", "cop[tikv]", "table:s", "tikv_task:{time:0s, loops:1}", "keep order:false", "N/A", "N/A"},
+		[]string{"└─TableReader_13", "9990.00", "3", "root", "", "time:1.1ms, loops:2, cop_task: {num: 1, max: 1ms, proc_keys: 5, tot_proc: 300µs}", "data:TableFullScan_12", "N/A", "N/A"},
+		[]string{"  └─TableFullScan_12", "9990.00", "5", "cop[tikv]", "table:t", "tikv_task:{time:0s, loops:1}", "keep order:false", "N/A", "N/A"},
+	)
+	info, err := NewExplainAnalyzeInfo(rows)
+	require.Nil(t, err)
+	require.Equal(t, ServerStats{
+		Latency:       3100 * time.Microsecond,
+		ProcessedKeys: 15,
+		Memory:        1536,
+		CPUTime:       1100 * time.Microsecond,
+	}, info.ServerStats())
+
+	// scans report the processed keys and time of the cop tasks summarized by their readers
+	rows = explainRows([]string{"id", "estRows", "actRows", "task", "access object", "execution info", "operator info", "memory", "disk"},
+		[]string{"TableReader_5", "10000.00", "10", "root", "", "time:1.2ms, loops:2, cop_task: {num: 1, max: 1ms, proc_keys: 10, tot_proc: 800µs}", "data:TableFullScan_4", "512 Bytes", "N/A"},
+		[]string{"└─TableFullScan_4", "10000.00", "10", "cop[tikv]", "table:t", "tikv_task:{time:0s, loops:1}, scan_detail: {total_process_keys: 10}, time_detail: {total_process_time: 800µs}", "keep order:false", "N/A", "N/A"},
+	)
+	info, err = NewExplainAnalyzeInfo(rows)
+	require.Nil(t, err)
+	require.Equal(t, ServerStats{
+		Latency:       1200 * time.Microsecond,
+		ProcessedKeys: 10,
+		Memory:        512,
+		CPUTime:       800 * time.Microsecond,
+	}, info.ServerStats())
+
+	// scans are counted when their readers report nothing
+	rows.Data[0][5] = []byte("time:1.2ms, loops:2")
+	info, err = NewExplainAnalyzeInfo(rows)
+	require.Nil(t, err)
+	require.Equal(t, int64(10), info.ServerStats().ProcessedKeys)
+}
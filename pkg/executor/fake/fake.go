@@ -0,0 +1,383 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fake provides a programmable executor.Pool for tests, it answers statements by registered responses
+// instead of connecting to a database.
+package fake
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/pingcap/tidb/errno"
+
+	"github.com/chaos-mesh/horoscope/pkg/executor"
+)
+
+// PlanOutOfRange is the warning of TiDB for a NTH_PLAN hint beyond the plan space
+var PlanOutOfRange error = &mysql.MySQLError{Number: errno.ErrUnknown, Message: "The parameter of nth_plan() is out of range."}
+
+type (
+	// Pool matches statements against patterns of responses, the latest registered response wins.
+	// Statements are the ones a real executor sends, like `EXPLAIN <query>`, `EXPLAIN ANALYZE <query>`
+	// and `explain format = 'hint' <query>`.
+	Pool struct {
+		dsn       string
+		variables map[string]string
+
+		mu         sync.Mutex
+		responses  []*Response
+		statements []string
+		conns      int
+		// open bounds dedicated connections held at the same time, nil means no bound
+		open chan struct{}
+	}
+
+	// Response is built by chaining, like `pool.On("EXPLAIN .*NTH_PLAN\\(3\\)").Warn(fake.PlanOutOfRange)`
+	Response struct {
+		pattern *regexp.Regexp
+		rows    executor.Rows
+		result  executor.Result
+		// warnings are returned as `SHOW WARNINGS` after the statement
+		warnings  []error
+		err       error
+		delay     time.Duration
+		fromCache bool
+		// times is the remaining count of statements to answer, negative means unlimited
+		times int
+	}
+
+	fakeExecutor struct {
+		pool *Pool
+	}
+
+	fakeTransaction struct {
+		fakeExecutor
+		release func()
+	}
+
+	fakeConn struct {
+		fakeExecutor
+		release func()
+	}
+
+	fakeStatement struct {
+		pool  *Pool
+		query string
+		last  *Response
+	}
+)
+
+func NewPool(dsn string) *Pool {
+	return &Pool{dsn: dsn}
+}
+
+// On registers a response for statements matching the regular expression pattern
+func (p *Pool) On(pattern string) *Response {
+	response := &Response{pattern: regexp.MustCompile(pattern), rows: Rows(nil), times: -1}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.responses = append(p.responses, response)
+	return response
+}
+
+// WithSessionVariables sets session variables reported by the pool
+func (p *Pool) WithSessionVariables(variables map[string]string) *Pool {
+	p.variables = variables
+	return p
+}
+
+// WithMaxConns bounds dedicated connections held at the same time like `MaxOpenConns`,
+// Conn and Transaction wait for a released one
+func (p *Pool) WithMaxConns(n int) *Pool {
+	p.open = make(chan struct{}, n)
+	return p
+}
+
+// Statements returns all statements sent to the pool in order
+func (p *Pool) Statements() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.statements...)
+}
+
+// Conns returns the count of dedicated connections taken from the pool
+func (p *Pool) Conns() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.conns
+}
+
+// Rows builds rows of columns from strings
+func Rows(columns []string, data ...[]string) executor.Rows {
+	rows := executor.Rows{ColumnMap: make(map[string]int), Data: make([]executor.Row, 0, len(data))}
+	for i, column := range columns {
+		rows.Columns = append(rows.Columns, []byte(column))
+		rows.ColumnMap[column] = i
+	}
+	for _, values := range data {
+		row := make(executor.Row, 0, len(values))
+		for _, value := range values {
+			row = append(row, []byte(value))
+		}
+		rows.Data = append(rows.Data, row)
+	}
+	return rows
+}
+
+// Return sets the result rows
+func (r *Response) Return(rows executor.Rows) *Response {
+	r.rows = rows
+	return r
+}
+
+// Affect sets the result of DML
+func (r *Response) Affect(rowsAffected int64) *Response {
+	r.result = executor.Result{RowsAffected: rowsAffected}
+	return r
+}
+
+// Warn sets warnings of EXPLAIN statements
+func (r *Response) Warn(warnings ...error) *Response {
+	r.warnings = warnings
+	return r
+}
+
+// Fail makes the statement fail with err
+func (r *Response) Fail(err error) *Response {
+	r.err = err
+	return r
+}
+
+// Delay makes the statement take d, it is interrupted with an *executor.TimeoutError once its context is done
+func (r *Response) Delay(d time.Duration) *Response {
+	r.delay = d
+	return r
+}
+
+// Times makes the response only answer n statements, later statements fall through to earlier responses
+func (r *Response) Times(n int) *Response {
+	r.times = n
+	return r
+}
+
+// FromCache makes prepared statements report a plan cache hit
+func (r *Response) FromCache() *Response {
+	r.fromCache = true
+	return r
+}
+
+func (p *Pool) respond(ctx context.Context, statement string) (*Response, error) {
+	p.mu.Lock()
+	p.statements = append(p.statements, statement)
+	var response *Response
+	for i := len(p.responses) - 1; i >= 0; i-- {
+		if p.responses[i].times != 0 && p.responses[i].pattern.MatchString(statement) {
+			response = p.responses[i]
+			if response.times > 0 {
+				response.times--
+			}
+			break
+		}
+	}
+	p.mu.Unlock()
+
+	if response == nil {
+		return nil, fmt.Errorf("fake: no response for %q", statement)
+	}
+	if response.delay != 0 {
+		timer := time.NewTimer(response.delay)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return nil, &executor.TimeoutError{Query: statement, Cause: ctx.Err()}
+		case <-timer.C:
+		}
+	}
+	return response, response.err
+}
+
+func (p *Pool) Dsn() string {
+	return p.dsn
+}
+
+func (p *Pool) Executor() executor.Executor {
+	return &fakeExecutor{pool: p}
+}
+
+func (p *Pool) Transaction() (executor.Transaction, error) {
+	return p.TransactionContext(context.Background())
+}
+
+func (p *Pool) TransactionContext(ctx context.Context) (executor.Transaction, error) {
+	release, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeTransaction{fakeExecutor{pool: p}, release}, nil
+}
+
+func (p *Pool) Conn(ctx context.Context) (executor.Conn, error) {
+	release, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	p.conns++
+	p.mu.Unlock()
+	return &fakeConn{fakeExecutor{pool: p}, release}, nil
+}
+
+// acquire waits for a connection under the bound, release is idempotent
+func (p *Pool) acquire(ctx context.Context) (release func(), err error) {
+	if p.open == nil {
+		return func() {}, nil
+	}
+	select {
+	case p.open <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			<-p.open
+		})
+	}, nil
+}
+
+func (p *Pool) SessionVariables() map[string]string {
+	return p.variables
+}
+
+func (e *fakeExecutor) Dsn() string {
+	return e.pool.dsn
+}
+
+func (e *fakeExecutor) Query(query string) (executor.Rows, error) {
+	return e.QueryContext(context.Background(), query)
+}
+
+func (e *fakeExecutor) QueryContext(ctx context.Context, query string) (executor.Rows, error) {
+	response, err := e.pool.respond(ctx, query)
+	if err != nil {
+		return executor.Rows{}, err
+	}
+	return response.rows, nil
+}
+
+func (e *fakeExecutor) QueryStream(query string) (executor.RowStream, error) {
+	return e.QueryStreamContext(context.Background(), query)
+}
+
+func (e *fakeExecutor) QueryStreamContext(ctx context.Context, query string) (executor.RowStream, error) {
+	rows, err := e.QueryContext(ctx, query)
+	if err != nil {
+		return executor.RowStream{}, err
+	}
+	return executor.NewRowStreamFromRows(rows), nil
+}
+
+func (e *fakeExecutor) Exec(query string) (executor.Result, error) {
+	return e.ExecContext(context.Background(), query)
+}
+
+func (e *fakeExecutor) ExecContext(ctx context.Context, query string) (executor.Result, error) {
+	response, err := e.pool.respond(ctx, query)
+	if err != nil {
+		return executor.Result{}, err
+	}
+	return response.result, nil
+}
+
+func (e *fakeExecutor) Prepare(query string) (executor.PreparedStatement, error) {
+	return &fakeStatement{pool: e.pool, query: query}, nil
+}
+
+func (e *fakeExecutor) GetHints(query string) (executor.Hints, error) {
+	rows, err := e.Query(fmt.Sprintf("explain format = 'hint' %s", query))
+	if err != nil {
+		return executor.Hints{}, err
+	}
+	if rows.RowCount() != 1 || rows.ColumnNums() != 1 {
+		return executor.Hints{}, fmt.Errorf("unexpected hints: %#v", rows)
+	}
+	return executor.NewHints(string(rows.Data[0][0])), nil
+}
+
+func (e *fakeExecutor) Explain(query string) (executor.Rows, []error, error) {
+	return e.ExplainContext(context.Background(), query)
+}
+
+func (e *fakeExecutor) ExplainContext(ctx context.Context, query string) (executor.Rows, []error, error) {
+	return e.explain(ctx, fmt.Sprintf("EXPLAIN %s", query))
+}
+
+func (e *fakeExecutor) ExplainAnalyze(query string) (executor.Rows, []error, error) {
+	return e.ExplainAnalyzeContext(context.Background(), query)
+}
+
+func (e *fakeExecutor) ExplainAnalyzeContext(ctx context.Context, query string) (executor.Rows, []error, error) {
+	return e.explain(ctx, fmt.Sprintf("EXPLAIN ANALYZE %s", query))
+}
+
+func (e *fakeExecutor) explain(ctx context.Context, statement string) (executor.Rows, []error, error) {
+	response, err := e.pool.respond(ctx, statement)
+	if err != nil {
+		if executor.IsTimeout(err) {
+			return executor.Rows{}, nil, err
+		}
+		return executor.Rows{}, nil, fmt.Errorf("explain error: %w", err)
+	}
+	return response.rows, append([]error{}, response.warnings...), nil
+}
+
+func (t *fakeTransaction) Commit() error {
+	t.release()
+	return nil
+}
+
+func (t *fakeTransaction) Rollback() error {
+	t.release()
+	return nil
+}
+
+func (c *fakeConn) Close() error {
+	c.release()
+	return nil
+}
+
+// QueryContext answers by the response of the prepared query, arguments are ignored
+func (s *fakeStatement) QueryContext(ctx context.Context, _ ...interface{}) (executor.Rows, error) {
+	response, err := s.pool.respond(ctx, s.query)
+	if err != nil {
+		return executor.Rows{}, err
+	}
+	s.last = response
+	return response.rows, nil
+}
+
+func (s *fakeStatement) LastPlanFromCache() (bool, error) {
+	if s.last == nil {
+		return false, nil
+	}
+	return s.last.fromCache, nil
+}
+
+func (s *fakeStatement) Close() error {
+	return nil
+}
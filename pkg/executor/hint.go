@@ -14,33 +14,137 @@
 package executor
 
 import (
-	"reflect"
+	"fmt"
+	"sort"
 	"strings"
+
+	"github.com/pingcap/parser"
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/format"
+	"github.com/pingcap/parser/mysql"
+	log "github.com/sirupsen/logrus"
 )
 
+// defaultQBName is the query block of hints without one
+const defaultQBName = "sel_1"
+
+// hintAliases maps hint names to their semantic names
+var hintAliases = map[string]string{
+	"tidb_hj":   "hash_join",
+	"tidb_smj":  "merge_join",
+	"tidb_inlj": "inl_join",
+}
+
+// Hints are optimizer hints of a plan parsed from `explain format = 'hint'`, NTH_PLAN is excluded
 type Hints struct {
-	segments map[string]bool
-	raw      string
+	items []*ast.TableOptimizerHint
+	// keys are semantic keys of items in the same order
+	keys []string
+	raw  string
+}
+
+// HintsDiff lists hints only in one side of the comparison
+type HintsDiff struct {
+	Removed []string
+	Added   []string
 }
 
 func NewHints(raw string) Hints {
-	hints := Hints{
-		segments: make(map[string]bool),
-		raw:      raw,
-	}
-	for _, hint := range strings.Split(raw, ",") {
-		segment := strings.Trim(hint, " ")
-		if !strings.Contains(segment, "nth_plan") {
-			hints.segments[segment] = true
+	hints := Hints{raw: raw}
+	items, errs := parser.ParseHint("/*+"+raw+"*/", mysql.ModeNone, parser.Pos{Line: 1})
+	for _, err := range errs {
+		log.WithFields(log.Fields{
+			"hints": raw,
+			"err":   err.Error(),
+		}).Warn("fail to parse hints")
+	}
+	for _, item := range items {
+		if item.HintName.L == "nth_plan" {
+			continue
 		}
+		hints.items = append(hints.items, item)
+		hints.keys = append(hints.keys, hintKey(item))
 	}
 	return hints
 }
 
+// Items returns the parsed hints
+func (h Hints) Items() []*ast.TableOptimizerHint {
+	return h.items
+}
+
+// Equal compares hints by semantic names, query blocks, tables and indexes, the order is ignored
 func (h Hints) Equal(other Hints) bool {
-	return reflect.DeepEqual(h.segments, other.segments)
+	diff := h.Diff(other)
+	return len(diff.Removed) == 0 && len(diff.Added) == 0
+}
+
+// Diff returns hints only in h as Removed and hints only in other as Added
+func (h Hints) Diff(other Hints) (diff HintsDiff) {
+	diff.Removed = h.subtract(other)
+	diff.Added = other.subtract(h)
+	return
+}
+
+func (h Hints) subtract(other Hints) (hints []string) {
+	keys := make(map[string]bool, len(other.keys))
+	for _, key := range other.keys {
+		keys[key] = true
+	}
+	for i, key := range h.keys {
+		if !keys[key] {
+			hints = append(hints, restoreHint(h.items[i]))
+		}
+	}
+	return
 }
 
 func (h Hints) String() string {
 	return h.raw
 }
+
+func (d HintsDiff) String() string {
+	items := make([]string, 0, len(d.Removed)+len(d.Added))
+	for _, hint := range d.Removed {
+		items = append(items, "-"+hint)
+	}
+	for _, hint := range d.Added {
+		items = append(items, "+"+hint)
+	}
+	return strings.Join(items, ", ")
+}
+
+// hintKey identifies a hint by its semantic name, query block, sorted tables and sorted indexes
+func hintKey(hint *ast.TableOptimizerHint) string {
+	name := hint.HintName.L
+	if alias, ok := hintAliases[name]; ok {
+		name = alias
+	}
+	qbName := hint.QBName.L
+	if qbName == "" {
+		qbName = defaultQBName
+	}
+	tables := make([]string, 0, len(hint.Tables))
+	for _, table := range hint.Tables {
+		tableQB := table.QBName.L
+		if tableQB == "" {
+			tableQB = qbName
+		}
+		tables = append(tables, fmt.Sprintf("%s.%s@%s", table.DBName.L, table.TableName.L, tableQB))
+	}
+	sort.Strings(tables)
+	indexes := make([]string, 0, len(hint.Indexes))
+	for _, index := range hint.Indexes {
+		indexes = append(indexes, index.L)
+	}
+	sort.Strings(indexes)
+	return fmt.Sprintf("%s@%s(%s;%s;%v)", name, qbName, strings.Join(tables, ","), strings.Join(indexes, ","), hint.HintData)
+}
+
+func restoreHint(hint *ast.TableOptimizerHint) string {
+	var builder strings.Builder
+	if err := hint.Restore(format.NewRestoreCtx(format.DefaultRestoreFlags, &builder)); err != nil {
+		return hint.HintName.O
+	}
+	return builder.String()
+}
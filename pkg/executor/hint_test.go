@@ -17,6 +17,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 type TestCase struct {
@@ -43,3 +44,26 @@ func TestHints_Equal(t *testing.T) {
 		}
 	}
 }
+
+func TestNewHints(t *testing.T) {
+	hints := NewHints("use_index(@`sel_1` `test`.`t` `idx_a`, `idx_b`), hash_join(@`sel_1` `test`.`t`, `test`.`s`), nth_plan(3)")
+	require.Len(t, hints.Items(), 2)
+	assert.Equal(t, "use_index", hints.Items()[0].HintName.L)
+	assert.Len(t, hints.Items()[0].Indexes, 2)
+	assert.Len(t, hints.Items()[1].Tables, 2)
+
+	// orders of hints, tables and indexes are ignored, aliases are the same hint
+	assert.True(t, hints.Equal(NewHints("tidb_hj(@`sel_1` `test`.`s`, `test`.`t`), use_index(@`sel_1` `test`.`t` `idx_b`, `idx_a`)")))
+	assert.False(t, hints.Equal(NewHints("use_index(@`sel_1` `test`.`t` `idx_a`), hash_join(@`sel_1` `test`.`t`, `test`.`s`)")))
+	assert.False(t, hints.Equal(NewHints("use_index(@`sel_2` `test`.`t` `idx_a`, `idx_b`), hash_join(@`sel_1` `test`.`t`, `test`.`s`)")))
+}
+
+func TestHints_Diff(t *testing.T) {
+	defaultHints := NewHints("use_index(@`sel_1` `test`.`t` ), hash_join(@`sel_1` `test`.`s`), hash_agg(@`sel_1`)")
+	betterHints := NewHints("use_index(@`sel_1` `test`.`t` `idx_a`), inl_join(@`sel_1` `test`.`s`), hash_agg(@`sel_1`)")
+	diff := defaultHints.Diff(betterHints)
+	assert.Equal(t, []string{"USE_INDEX(@`sel_1` `test`.`t` )", "HASH_JOIN(@`sel_1` `test`.`s`)"}, diff.Removed)
+	assert.Equal(t, []string{"USE_INDEX(@`sel_1` `test`.`t` `idx_a`)", "INL_JOIN(@`sel_1` `test`.`s`)"}, diff.Added)
+	assert.Equal(t, "-USE_INDEX(@`sel_1` `test`.`t` ), -HASH_JOIN(@`sel_1` `test`.`s`), +USE_INDEX(@`sel_1` `test`.`t` `idx_a`), +INL_JOIN(@`sel_1` `test`.`s`)", diff.String())
+	assert.Empty(t, defaultHints.Diff(defaultHints).String())
+}
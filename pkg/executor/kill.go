@@ -0,0 +1,135 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package executor
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// TimeoutError is returned when a statement is interrupted because its context is done
+type TimeoutError struct {
+	Query string
+	Cause error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("statement interrupted(%v): %s", e.Cause, e.Query)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Cause
+}
+
+func IsTimeout(err error) bool {
+	var timeoutErr *TimeoutError
+	return errors.As(err, &timeoutErr)
+}
+
+func interrupted(ctx context.Context, query string, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return &TimeoutError{Query: query, Cause: ctx.Err()}
+	}
+	return err
+}
+
+// statement prepares a connection for a statement, the statement running on it would be killed once ctx is done.
+// The release func must be called after the statement and its results are consumed.
+func (e *ExecutorImpl) statement(ctx context.Context) (exec RawExecutor, stmtCtx context.Context, release func(), err error) {
+	if ctx.Done() == nil || e.db == nil {
+		return e.exec, ctx, func() {}, nil
+	}
+
+	var (
+		connID    uint64
+		closeConn = func() {}
+	)
+	// a pinned connection must survive the interruption, so we only rely on `KILL QUERY`
+	exec, stmtCtx = e.exec, context.Background()
+	if e.pinned {
+		e.connIDOnce.Do(func() {
+			e.connID, e.connIDErr = connectionID(e.exec)
+		})
+		connID, err = e.connID, e.connIDErr
+	} else {
+		var conn *sql.Conn
+		conn, err = e.db.Conn(ctx)
+		if err != nil {
+			err = interrupted(ctx, "", err)
+			return
+		}
+		exec, stmtCtx, closeConn = conn, ctx, func() { conn.Close() }
+		connID, err = connectionID(conn)
+	}
+	if err != nil {
+		closeConn()
+		return
+	}
+
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			e.kill(connID)
+		case <-done:
+		}
+	}()
+
+	var once sync.Once
+	release = func() {
+		once.Do(func() {
+			close(done)
+			<-stopped
+			closeConn()
+		})
+	}
+	return
+}
+
+func (e *ExecutorImpl) kill(connID uint64) {
+	// TiDB ignores `KILL QUERY` unless `compatible-kill-query` is enabled
+	_, err := e.db.Exec(fmt.Sprintf("KILL TIDB QUERY %d", connID))
+	if err != nil {
+		_, err = e.db.Exec(fmt.Sprintf("KILL QUERY %d", connID))
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"connection": connID,
+			"err":        err.Error(),
+		}).Warn("fail to kill query")
+	}
+}
+
+func connectionID(exec RawExecutor) (id uint64, err error) {
+	data, err := exec.QueryContext(context.Background(), "SELECT CONNECTION_ID()")
+	if err != nil {
+		return
+	}
+	rows, err := NewRows(data)
+	if err != nil {
+		return
+	}
+	if rows.RowCount() != 1 || rows.ColumnNums() != 1 {
+		err = fmt.Errorf("unexpected connection id: %#v", rows)
+		return
+	}
+	return strconv.ParseUint(string(rows.Data[0][0]), 10, 64)
+}
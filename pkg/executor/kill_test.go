@@ -0,0 +1,196 @@
+package executor
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubServer runs `SELECT SLEEP(...)` until its connection is killed, like a server ignoring the closed client
+type stubServer struct {
+	mu        sync.Mutex
+	nextID    uint64
+	connIDs   map[uint64]int
+	kills     []uint64
+	killed    map[uint64]chan struct{}
+	failConns bool
+}
+
+func newStubPool() (*PoolImpl, *stubServer) {
+	server := &stubServer{connIDs: make(map[uint64]int), killed: make(map[uint64]chan struct{})}
+	return &PoolImpl{dsn: "stub", db: sql.OpenDB(server), flavor: &serverFlavor{}}, server
+}
+
+func (s *stubServer) Connect(context.Context) (driver.Conn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	s.killed[s.nextID] = make(chan struct{}, 1)
+	return &stubConn{server: s, id: s.nextID}, nil
+}
+
+func (s *stubServer) Driver() driver.Driver {
+	return nil
+}
+
+func (s *stubServer) killedQueries() []uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]uint64(nil), s.kills...)
+}
+
+type stubConn struct {
+	server *stubServer
+	id     uint64
+}
+
+func (c *stubConn) QueryContext(ctx context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	s := c.server
+	switch {
+	case query == "SELECT CONNECTION_ID()":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.failConns {
+			return nil, errors.New("connection id unavailable")
+		}
+		s.connIDs[c.id]++
+		return &stubRows{columns: []string{"CONNECTION_ID()"}, data: [][]string{{strconv.FormatUint(c.id, 10)}}}, nil
+	case query == "SELECT VERSION()":
+		return &stubRows{columns: []string{"VERSION()"}, data: [][]string{{"5.7.25-TiDB-v4.0.6"}}}, nil
+	case query == "SHOW WARNINGS;":
+		return &stubRows{columns: []string{"Level", "Code", "Message"}}, nil
+	case strings.Contains(query, "SLEEP"):
+		s.mu.Lock()
+		killed := s.killed[c.id]
+		s.mu.Unlock()
+		select {
+		case <-killed:
+			return nil, errors.New("Query execution was interrupted")
+		case <-time.After(5 * time.Second):
+			return nil, errors.New("query is never killed")
+		}
+	default:
+		return &stubRows{columns: []string{"1"}, data: [][]string{{"1"}}}, nil
+	}
+}
+
+func (c *stubConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	var id uint64
+	if _, err := fmt.Sscanf(query, "KILL TIDB QUERY %d", &id); err != nil {
+		return nil, fmt.Errorf("unexpected statement: %s", query)
+	}
+	s := c.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.kills = append(s.kills, id)
+	s.killed[id] <- struct{}{}
+	return driver.RowsAffected(0), nil
+}
+
+func (c *stubConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+
+func (c *stubConn) Close() error {
+	return nil
+}
+
+func (c *stubConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("not supported")
+}
+
+type stubRows struct {
+	columns []string
+	data    [][]string
+}
+
+func (r *stubRows) Columns() []string {
+	return r.columns
+}
+
+func (r *stubRows) Close() error {
+	return nil
+}
+
+func (r *stubRows) Next(dest []driver.Value) error {
+	if len(r.data) == 0 {
+		return io.EOF
+	}
+	for i, value := range r.data[0] {
+		dest[i] = []byte(value)
+	}
+	r.data = r.data[1:]
+	return nil
+}
+
+func TestExecutor_KillQuery(t *testing.T) {
+	pool, server := newStubPool()
+	exec := pool.Executor()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := exec.QueryContext(ctx, "SELECT SLEEP(10)")
+	require.NotNil(t, err)
+	assert.True(t, IsTimeout(err))
+	assert.Len(t, server.killedQueries(), 1)
+	assert.Equal(t, 0, pool.db.Stats().InUse)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, _, err = exec.ExplainContext(ctx, "SELECT SLEEP(10)")
+	require.NotNil(t, err)
+	assert.True(t, IsTimeout(err))
+	assert.True(t, IsTimeout(fmt.Errorf("explain error: %w", err)))
+	assert.Len(t, server.killedQueries(), 2)
+	assert.Equal(t, 0, pool.db.Stats().InUse)
+}
+
+func TestExecutor_KillPinnedQuery(t *testing.T) {
+	pool, server := newStubPool()
+	conn, err := pool.Conn(context.Background())
+	require.Nil(t, err)
+
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		_, err = conn.QueryContext(ctx, "SELECT SLEEP(10)")
+		cancel()
+		require.NotNil(t, err)
+		assert.True(t, IsTimeout(err))
+	}
+
+	// the connection id is queried once and the pinned connection survives kills
+	id := conn.(*ConnImpl).connID
+	assert.Equal(t, []uint64{id, id}, server.killedQueries())
+	assert.Equal(t, 1, server.connIDs[id])
+	rows, err := conn.Query("SELECT 1")
+	require.Nil(t, err)
+	assert.Equal(t, 1, rows.RowCount())
+	require.Nil(t, conn.Close())
+	assert.Equal(t, 0, pool.db.Stats().InUse)
+}
+
+func TestExecutor_ReleaseOnEarlyReturn(t *testing.T) {
+	pool, server := newStubPool()
+	server.failConns = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := pool.Executor().QueryContext(ctx, "SELECT SLEEP(10)")
+	require.NotNil(t, err)
+	assert.False(t, IsTimeout(err))
+	_, err = pool.Executor().QueryStreamContext(ctx, "SELECT SLEEP(10)")
+	require.NotNil(t, err)
+	assert.Empty(t, server.killedQueries())
+	assert.Equal(t, 0, pool.db.Stats().InUse)
+}
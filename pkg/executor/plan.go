@@ -0,0 +1,258 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package executor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	operatorIDRegex = regexp.MustCompile(`^([a-zA-Z]+)(?:_(\d+))?(?:\((\w+)\))?`)
+
+	// operator ids and generated columns in operator info, like `data:TableFullScan_307` or `Column#43`,
+	// ids are only normalized if they refer to operators of the plan, user identifiers like `index:Idx_1` look the same
+	opInfoIDRegex     = regexp.MustCompile(`\b([A-Z][a-zA-Z]+)_\d+\b`)
+	opInfoColumnRegex = regexp.MustCompile(`\bColumn#\d+`)
+)
+
+type (
+	// PlanTree is the operator tree of a plan, built from the output of EXPLAIN
+	PlanTree struct {
+		Root *PlanNode
+		rows Rows
+	}
+
+	PlanNode struct {
+		// Op is the operator name, like `HashJoin`
+		Op string
+		// ID is the operator id, like `45` of `HashJoin_45`
+		ID string
+		// Label is the role of operator in its parent, like `Build` or `Probe`
+		Label        string
+		Task         string
+		AccessObject string
+		EstRows      float64
+		// EstCost is zero if the server does not output it
+		EstCost  float64
+		OpInfo   string
+		Children []*PlanNode
+	}
+)
+
+// NewPlanTree builds a plan tree from the rows of EXPLAIN, the layout is detected from the header
+func NewPlanTree(data Rows) (tree *PlanTree, err error) {
+	layout, err := detectLayout(data)
+	if err != nil {
+		return nil, err
+	}
+	tree = &PlanTree{rows: data}
+	if layout == layoutTree {
+		err = tree.buildFromTree(data)
+	} else {
+		err = tree.buildFromTable(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (t *PlanTree) buildFromTable(data Rows) (err error) {
+	idIdx, _ := data.columnIndex("id")
+	estRowsIdx, ok := data.columnIndex("estRows")
+	if !ok {
+		// TiDB 3.x
+		estRowsIdx, ok = data.columnIndex("count")
+	}
+	if !ok {
+		estRowsIdx = -1
+	}
+
+	var stack []*PlanNode
+	for _, row := range data.Data {
+		var node *PlanNode
+		var level int
+		node, level, err = parseOperatorID(string(row[idIdx]))
+		if err != nil {
+			return
+		}
+		if estRowsIdx >= 0 {
+			if node.EstRows, err = parseFloatColumn(string(row[estRowsIdx])); err != nil {
+				return fmt.Errorf("invalid estRows of %s: %v", row[idIdx], err)
+			}
+		}
+		if idx, ok := data.columnIndex("estCost"); ok {
+			if node.EstCost, err = parseFloatColumn(string(row[idx])); err != nil {
+				return fmt.Errorf("invalid estCost of %s: %v", row[idIdx], err)
+			}
+		}
+		if idx, ok := data.columnIndex("task"); ok {
+			node.Task = string(row[idx])
+		}
+		if idx, ok := data.columnIndex("access object"); ok {
+			node.AccessObject = string(row[idx])
+		}
+		if idx, ok := data.columnIndex("operator info"); ok {
+			node.OpInfo = string(row[idx])
+		}
+
+		if stack, err = t.attach(stack, node, level); err != nil {
+			return
+		}
+	}
+	if t.Root == nil {
+		return fmt.Errorf("empty explanation")
+	}
+	return
+}
+
+// buildFromTree parses `EXPLAIN FORMAT=TREE` of MySQL 8 by walkTree
+func (t *PlanTree) buildFromTree(data Rows) error {
+	var nodes []*PlanNode
+	return walkTree(data, func(line treeLine, parent int) (err error) {
+		node := &PlanNode{Op: treeOperator(line.desc), OpInfo: line.desc}
+		if table := treeTableRegex.FindStringSubmatch(line.desc); table != nil {
+			node.AccessObject = "table:" + table[1]
+		}
+		if line.cost != "" {
+			if node.EstCost, err = parseFloatColumn(line.cost); err != nil {
+				return fmt.Errorf("invalid cost of %s: %v", line.desc, err)
+			}
+			if node.EstRows, err = parseFloatColumn(line.rows); err != nil {
+				return fmt.Errorf("invalid rows of %s: %v", line.desc, err)
+			}
+		}
+		if parent < 0 {
+			t.Root = node
+		} else {
+			nodes[parent].Children = append(nodes[parent].Children, node)
+		}
+		nodes = append(nodes, node)
+		return
+	})
+}
+
+// attach appends node to its parent in stack, which holds the last node of each level
+func (t *PlanTree) attach(stack []*PlanNode, node *PlanNode, level int) ([]*PlanNode, error) {
+	if level > len(stack) {
+		return nil, fmt.Errorf("operator %s has no parent", node.Op)
+	}
+	stack = stack[:level]
+	if level == 0 {
+		if t.Root != nil {
+			return nil, fmt.Errorf("explanation has more than one root: %s", node.Op)
+		}
+		t.Root = node
+	} else {
+		parent := stack[level-1]
+		parent.Children = append(parent.Children, node)
+	}
+	return append(stack, node), nil
+}
+
+func (r Rows) columnIndex(name string) (int, bool) {
+	for i, column := range r.Columns {
+		if string(column) == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// parseOperatorID parses ids like `  │ └─TableFullScan_307(Build)`, each level is indented by two characters
+func parseOperatorID(str string) (node *PlanNode, level int, err error) {
+	start := strings.IndexAny(str, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
+	if start < 0 {
+		return nil, 0, fmt.Errorf("invalid operator id: %s", str)
+	}
+	level = utf8.RuneCountInString(str[0:start]) / 2
+	matches := operatorIDRegex.FindStringSubmatch(str[start:])
+	node = &PlanNode{Op: matches[1], ID: matches[2], Label: matches[3]}
+	return
+}
+
+// Walk visits all nodes of the tree in pre-order
+func (t *PlanTree) Walk(fn func(node *PlanNode, depth int)) {
+	if t != nil {
+		t.Root.walk(fn, 0)
+	}
+}
+
+func (n *PlanNode) walk(fn func(node *PlanNode, depth int), depth int) {
+	fn(n, depth)
+	for _, child := range n.Children {
+		child.walk(fn, depth+1)
+	}
+}
+
+// Equal means two plans have the same shape and estimations
+func (t *PlanTree) Equal(other Comparable) bool {
+	otherTree, ok := other.(*PlanTree)
+	if !ok || t == nil || otherTree == nil {
+		return false
+	}
+	return t.Root.Equal(otherTree.Root)
+}
+
+func (n *PlanNode) Equal(other *PlanNode) bool {
+	if n.Op != other.Op || n.ID != other.ID || n.Label != other.Label || n.Task != other.Task ||
+		n.AccessObject != other.AccessObject || n.EstRows != other.EstRows || n.EstCost != other.EstCost ||
+		n.OpInfo != other.OpInfo || len(n.Children) != len(other.Children) {
+		return false
+	}
+	for i, child := range n.Children {
+		if !child.Equal(other.Children[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Digest is a normalized fingerprint of the plan shape, it ignores operator numbering and estimations,
+// so plans only differ in cost noise share the same digest
+func (t *PlanTree) Digest() string {
+	if t == nil {
+		return ""
+	}
+	operators := make(map[string]string)
+	t.Walk(func(node *PlanNode, depth int) {
+		if node.ID != "" {
+			operators[node.Op+"_"+node.ID] = node.Op
+		}
+	})
+	hash := sha256.New()
+	t.Walk(func(node *PlanNode, depth int) {
+		opInfo := opInfoIDRegex.ReplaceAllStringFunc(node.OpInfo, func(id string) string {
+			if op, ok := operators[id]; ok {
+				return op
+			}
+			return id
+		})
+		opInfo = opInfoColumnRegex.ReplaceAllString(opInfo, "Column")
+		fmt.Fprintf(hash, "%d|%s|%s|%s|%s|%s\n", depth, node.Op, node.Label, node.Task, node.AccessObject, opInfo)
+	})
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func (t *PlanTree) String() string {
+	if t == nil {
+		return ""
+	}
+	return t.rows.String()
+}
@@ -0,0 +1,116 @@
+package executor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func explainRows(columns []string, data ...[]string) Rows {
+	rows := Rows{}
+	for _, column := range columns {
+		rows.Columns = append(rows.Columns, []byte(column))
+	}
+	for _, values := range data {
+		var row Row
+		for _, value := range values {
+			row = append(row, []byte(value))
+		}
+		rows.Data = append(rows.Data, row)
+	}
+	return rows
+}
+
+func TestNewPlanTree(t *testing.T) {
+	rows := explainRows([]string{"id", "estRows", "task", "access object", "operator info"},
+		[]string{"HashAgg_30", "1.00", "root", "", "funcs:min(imdb.char_name.name)->Column#43"},
+		[]string{"└─HashJoin_45", "76450.66", "root", "", "inner join, equal:[eq(imdb.movie_companies.company_type_id, imdb.company_type.id)]"},
+		[]string{"  ├─TableReader_308(Build)", "4.00", "root", "", "data:TableFullScan_307"},
+		[]string{"  │ └─TableFullScan_307", "4.00", "cop[tikv]", "table:ct", "keep order:false"},
+		[]string{"  └─HashJoin_74(Probe)", "76450.66", "root", "", ""},
+	)
+	tree, err := NewPlanTree(rows)
+	require.Nil(t, err)
+	require.Equal(t, "HashAgg", tree.Root.Op)
+	require.Equal(t, "30", tree.Root.ID)
+	join := tree.Root.Children[0]
+	require.Equal(t, "HashJoin", join.Op)
+	require.Equal(t, 76450.66, join.EstRows)
+	require.Len(t, join.Children, 2)
+	require.Equal(t, "Build", join.Children[0].Label)
+	require.Equal(t, "TableFullScan", join.Children[0].Children[0].Op)
+	require.Equal(t, "cop[tikv]", join.Children[0].Children[0].Task)
+	require.Equal(t, "table:ct", join.Children[0].Children[0].AccessObject)
+	require.Equal(t, "Probe", join.Children[1].Label)
+
+	var ops []string
+	tree.Walk(func(node *PlanNode, depth int) {
+		ops = append(ops, node.Op)
+	})
+	require.Equal(t, []string{"HashAgg", "HashJoin", "TableReader", "TableFullScan", "HashJoin"}, ops)
+
+	other, err := NewPlanTree(rows)
+	require.Nil(t, err)
+	require.True(t, tree.Equal(other))
+	other.Root.Children[0].Children[1].Op = "MergeJoin"
+	require.False(t, tree.Equal(other))
+}
+
+func TestNewPlanTreeWithEstCost(t *testing.T) {
+	rows := explainRows([]string{"id", "estRows", "estCost", "task", "access object", "operator info"},
+		[]string{"TableReader_5", "10000.00", "177906.67", "root", "", "data:TableFullScan_4"},
+		[]string{"└─TableFullScan_4", "10000.00", "2035000.00", "cop[tikv]", "table:t", "keep order:false, stats:pseudo"},
+	)
+	tree, err := NewPlanTree(rows)
+	require.Nil(t, err)
+	require.Equal(t, 177906.67, tree.Root.EstCost)
+	require.Equal(t, 2035000.00, tree.Root.Children[0].EstCost)
+
+	_, err = NewPlanTree(explainRows([]string{"estRows"}, []string{"1.00"}))
+	require.NotNil(t, err)
+}
+
+func TestPlanTree_Digest(t *testing.T) {
+	columns := []string{"id", "estRows", "task", "access object", "operator info"}
+	tree, err := NewPlanTree(explainRows(columns,
+		[]string{"Projection_4", "1.00", "root", "", "Column#5"},
+		[]string{"└─TableReader_7", "3.00", "root", "", "data:TableFullScan_6"},
+		[]string{"  └─TableFullScan_6", "3.00", "cop[tikv]", "table:t", "keep order:false"},
+	))
+	require.Nil(t, err)
+	renumbered, err := NewPlanTree(explainRows(columns,
+		[]string{"Projection_12", "1.20", "root", "", "Column#9"},
+		[]string{"└─TableReader_15", "3.50", "root", "", "data:TableFullScan_14"},
+		[]string{"  └─TableFullScan_14", "3.50", "cop[tikv]", "table:t", "keep order:false"},
+	))
+	require.Nil(t, err)
+	require.Equal(t, tree.Digest(), renumbered.Digest())
+
+	different, err := NewPlanTree(explainRows(columns,
+		[]string{"Projection_4", "1.00", "root", "", "Column#5"},
+		[]string{"└─IndexReader_7", "3.00", "root", "", "index:IndexFullScan_6"},
+		[]string{"  └─IndexFullScan_6", "3.00", "cop[tikv]", "table:t, index:idx(a)", "keep order:false"},
+	))
+	require.Nil(t, err)
+	require.NotEqual(t, tree.Digest(), different.Digest())
+
+	// TiDB 3.x puts access objects into operator info
+	legacy := []string{"id", "count", "task", "operator info"}
+	idx1, err := NewPlanTree(explainRows(legacy,
+		[]string{"IndexReader_6", "3.00", "root", "index:IndexScan_5"},
+		[]string{"└─IndexScan_5", "3.00", "cop[tikv]", "table:t, index:Idx_1, range:[NULL,+inf], keep order:false"},
+	))
+	require.Nil(t, err)
+	idx2, err := NewPlanTree(explainRows(legacy,
+		[]string{"IndexReader_6", "3.00", "root", "index:IndexScan_5"},
+		[]string{"└─IndexScan_5", "3.00", "cop[tikv]", "table:t, index:Idx_2, range:[NULL,+inf], keep order:false"},
+	))
+	require.Nil(t, err)
+	require.NotEqual(t, idx1.Digest(), idx2.Digest())
+	renumberedIdx1, err := NewPlanTree(explainRows(legacy,
+		[]string{"IndexReader_9", "3.00", "root", "index:IndexScan_8"},
+		[]string{"└─IndexScan_8", "3.00", "cop[tikv]", "table:t, index:Idx_1, range:[NULL,+inf], keep order:false"},
+	))
+	require.Nil(t, err)
+	require.Equal(t, idx1.Digest(), renumberedIdx1.Digest())
+}
@@ -0,0 +1,99 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package executor
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+type (
+	// PreparedStatement is a server-side prepared statement of the binary protocol,
+	// all executions run in the same session so that they share its plan cache
+	PreparedStatement interface {
+		QueryContext(ctx context.Context, args ...interface{}) (Rows, error)
+		// LastPlanFromCache reports whether the last execution used a cached plan, only TiDB supports it
+		LastPlanFromCache() (bool, error)
+		Close() error
+	}
+
+	PreparedStatementImpl struct {
+		query string
+		stmt  *sql.Stmt
+		// session is pinned on the connection of stmt
+		session   *ExecutorImpl
+		closeConn func() error
+	}
+)
+
+// Prepare prepares the query on a dedicated connection, or on the connection of the transaction
+func (e *ExecutorImpl) Prepare(query string) (PreparedStatement, error) {
+	session, closeConn := e, func() error { return nil }
+	if !e.pinned && e.db != nil {
+		conn, err := e.db.Conn(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		session = &ExecutorImpl{dsn: e.dsn, exec: conn, db: e.db, pinned: true, flavor: e.flavor}
+		closeConn = conn.Close
+	}
+	stmt, err := session.exec.PrepareContext(context.Background(), query)
+	if err != nil {
+		closeConn()
+		return nil, fmt.Errorf("prepare error: %w", err)
+	}
+	return &PreparedStatementImpl{query: query, stmt: stmt, session: session, closeConn: closeConn}, nil
+}
+
+func (p *PreparedStatementImpl) QueryContext(ctx context.Context, args ...interface{}) (rows Rows, err error) {
+	_, stmtCtx, release, err := p.session.statement(ctx)
+	if err != nil {
+		return
+	}
+	defer release()
+	data, err := p.stmt.QueryContext(stmtCtx, args...)
+	if err != nil {
+		err = interrupted(ctx, p.query, err)
+		return
+	}
+	rows, err = NewRows(data)
+	err = interrupted(ctx, p.query, err)
+	return
+}
+
+func (p *PreparedStatementImpl) LastPlanFromCache() (fromCache bool, err error) {
+	data, err := p.session.exec.QueryContext(context.Background(), "SELECT @@last_plan_from_cache")
+	if err != nil {
+		return
+	}
+	rows, err := NewRows(data)
+	if err != nil {
+		return
+	}
+	if rows.RowCount() != 1 || rows.ColumnNums() != 1 {
+		err = fmt.Errorf("unexpected last_plan_from_cache: %#v", rows)
+		return
+	}
+	return string(rows.Data[0][0]) == "1", nil
+}
+
+// Close deallocates the statement and releases its dedicated connection
+func (p *PreparedStatementImpl) Close() error {
+	err := p.stmt.Close()
+	if closeErr := p.closeConn(); err == nil {
+		err = closeErr
+	}
+	return err
+}
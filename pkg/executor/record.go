@@ -0,0 +1,346 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package executor
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/go-sql-driver/mysql"
+	log "github.com/sirupsen/logrus"
+)
+
+type RecordKind string
+
+const (
+	RecordQuery          RecordKind = "query"
+	RecordExec           RecordKind = "exec"
+	RecordHints          RecordKind = "hints"
+	RecordExplain        RecordKind = "explain"
+	RecordExplainAnalyze RecordKind = "explain_analyze"
+	RecordPrepare        RecordKind = "prepare"
+	RecordPreparedQuery  RecordKind = "prepared_query"
+	RecordPlanFromCache  RecordKind = "plan_from_cache"
+)
+
+type (
+	// Record is a statement with its response, records are stored as json lines.
+	// Dsn is stored without the password
+	Record struct {
+		Dsn   string     `json:"dsn"`
+		Kind  RecordKind `json:"kind"`
+		Query string     `json:"query"`
+		// Args are json encoded arguments of a prepared statement
+		Args      string          `json:"args,omitempty"`
+		Rows      *RecordedRows   `json:"rows,omitempty"`
+		Result    *Result         `json:"result,omitempty"`
+		Hints     string          `json:"hints,omitempty"`
+		Warnings  []RecordedError `json:"warnings,omitempty"`
+		FromCache bool            `json:"fromCache,omitempty"`
+		Error     *RecordedError  `json:"error,omitempty"`
+	}
+
+	RecordedRows struct {
+		Columns []string `json:"columns"`
+		// Types are database type names of columns
+		Types []string `json:"types"`
+		Data  []Row    `json:"data"`
+	}
+
+	// RecordedError keeps the number of a MySQL error and whether it is a timeout
+	RecordedError struct {
+		Number  uint16 `json:"number,omitempty"`
+		Message string `json:"message"`
+		Timeout bool   `json:"timeout,omitempty"`
+	}
+
+	// Recorder writes every statement of the pools it wraps with its response to a file
+	Recorder struct {
+		mu     sync.Mutex
+		file   *os.File
+		writer *bufio.Writer
+	}
+
+	recordingPool struct {
+		pool     Pool
+		recorder *Recorder
+	}
+
+	recordingExecutor struct {
+		exec     Executor
+		recorder *Recorder
+	}
+
+	recordingTransaction struct {
+		recordingExecutor
+		tx Transaction
+	}
+
+	recordingConn struct {
+		recordingExecutor
+		conn Conn
+	}
+
+	recordingStatement struct {
+		stmt     PreparedStatement
+		dsn      string
+		query    string
+		recorder *Recorder
+	}
+)
+
+func NewRecorder(path string) (*Recorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{file: file, writer: bufio.NewWriter(file)}, nil
+}
+
+// Pool wraps the pool, statements of its executors and transactions are recorded
+func (r *Recorder) Pool(pool Pool) Pool {
+	return &recordingPool{pool: pool, recorder: r}
+}
+
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	err := r.writer.Flush()
+	if closeErr := r.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (r *Recorder) record(record Record) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"query": record.Query,
+			"err":   err.Error(),
+		}).Warn("fail to record the statement")
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.writer.Write(data)
+	r.writer.WriteByte('\n')
+}
+
+func recordRows(rows Rows, err error) *RecordedRows {
+	if err != nil {
+		return nil
+	}
+	return &RecordedRows{Columns: rowStrings(rows.Columns), Types: rows.databaseTypes(), Data: rows.Data}
+}
+
+func recordError(err error) *RecordedError {
+	if err == nil {
+		return nil
+	}
+	if mysqlErr, ok := err.(*mysql.MySQLError); ok {
+		return &RecordedError{Number: mysqlErr.Number, Message: mysqlErr.Message}
+	}
+	return &RecordedError{Message: err.Error(), Timeout: IsTimeout(err)}
+}
+
+func recordWarnings(warnings []error) []RecordedError {
+	recorded := make([]RecordedError, 0, len(warnings))
+	for _, warning := range warnings {
+		recorded = append(recorded, *recordError(warning))
+	}
+	return recorded
+}
+
+func rowStrings(row Row) []string {
+	values := make([]string, 0, len(row))
+	for _, value := range row {
+		values = append(values, string(value))
+	}
+	return values
+}
+
+func (p *recordingPool) Dsn() string {
+	return p.pool.Dsn()
+}
+
+func (p *recordingPool) Executor() Executor {
+	return &recordingExecutor{exec: p.pool.Executor(), recorder: p.recorder}
+}
+
+func (p *recordingPool) Transaction() (Transaction, error) {
+	return p.TransactionContext(context.Background())
+}
+
+func (p *recordingPool) TransactionContext(ctx context.Context) (Transaction, error) {
+	tx, err := p.pool.TransactionContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &recordingTransaction{recordingExecutor: recordingExecutor{exec: tx, recorder: p.recorder}, tx: tx}, nil
+}
+
+func (p *recordingPool) Conn(ctx context.Context) (Conn, error) {
+	conn, err := p.pool.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &recordingConn{recordingExecutor: recordingExecutor{exec: conn, recorder: p.recorder}, conn: conn}, nil
+}
+
+func (p *recordingPool) SessionVariables() map[string]string {
+	return p.pool.SessionVariables()
+}
+
+func (e *recordingExecutor) Dsn() string {
+	return e.exec.Dsn()
+}
+
+func (e *recordingExecutor) Query(query string) (Rows, error) {
+	return e.QueryContext(context.Background(), query)
+}
+
+func (e *recordingExecutor) QueryContext(ctx context.Context, query string) (rows Rows, err error) {
+	rows, err = e.exec.QueryContext(ctx, query)
+	e.recorder.record(Record{Dsn: RedactDsn(e.Dsn()), Kind: RecordQuery, Query: query, Rows: recordRows(rows, err), Error: recordError(err)})
+	return
+}
+
+func (e *recordingExecutor) QueryStream(query string) (RowStream, error) {
+	return e.QueryStreamContext(context.Background(), query)
+}
+
+// QueryStreamContext reads the whole stream to record it, so the recording run holds results in memory
+func (e *recordingExecutor) QueryStreamContext(ctx context.Context, query string) (stream RowStream, err error) {
+	stream, err = e.exec.QueryStreamContext(ctx, query)
+	var rows Rows
+	if err == nil {
+		rows, err = stream.drain()
+	}
+	e.recorder.record(Record{Dsn: RedactDsn(e.Dsn()), Kind: RecordQuery, Query: query, Rows: recordRows(rows, err), Error: recordError(err)})
+	if err != nil {
+		return
+	}
+	return NewRowStreamFromRows(rows), nil
+}
+
+func (e *recordingExecutor) Exec(query string) (Result, error) {
+	return e.ExecContext(context.Background(), query)
+}
+
+func (e *recordingExecutor) ExecContext(ctx context.Context, query string) (result Result, err error) {
+	result, err = e.exec.ExecContext(ctx, query)
+	record := Record{Dsn: RedactDsn(e.Dsn()), Kind: RecordExec, Query: query, Error: recordError(err)}
+	if err == nil {
+		record.Result = &result
+	}
+	e.recorder.record(record)
+	return
+}
+
+func (e *recordingExecutor) Prepare(query string) (PreparedStatement, error) {
+	stmt, err := e.exec.Prepare(query)
+	e.recorder.record(Record{Dsn: RedactDsn(e.Dsn()), Kind: RecordPrepare, Query: query, Error: recordError(err)})
+	if err != nil {
+		return nil, err
+	}
+	return &recordingStatement{stmt: stmt, dsn: RedactDsn(e.Dsn()), query: query, recorder: e.recorder}, nil
+}
+
+func (e *recordingExecutor) GetHints(query string) (hints Hints, err error) {
+	hints, err = e.exec.GetHints(query)
+	e.recorder.record(Record{Dsn: RedactDsn(e.Dsn()), Kind: RecordHints, Query: query, Hints: hints.String(), Error: recordError(err)})
+	return
+}
+
+func (e *recordingExecutor) Explain(query string) (Rows, []error, error) {
+	return e.ExplainContext(context.Background(), query)
+}
+
+func (e *recordingExecutor) ExplainContext(ctx context.Context, query string) (rows Rows, warnings []error, err error) {
+	rows, warnings, err = e.exec.ExplainContext(ctx, query)
+	e.recordExplain(RecordExplain, query, rows, warnings, err)
+	return
+}
+
+func (e *recordingExecutor) ExplainAnalyze(query string) (Rows, []error, error) {
+	return e.ExplainAnalyzeContext(context.Background(), query)
+}
+
+func (e *recordingExecutor) ExplainAnalyzeContext(ctx context.Context, query string) (rows Rows, warnings []error, err error) {
+	rows, warnings, err = e.exec.ExplainAnalyzeContext(ctx, query)
+	e.recordExplain(RecordExplainAnalyze, query, rows, warnings, err)
+	return
+}
+
+func (e *recordingExecutor) recordExplain(kind RecordKind, query string, rows Rows, warnings []error, err error) {
+	e.recorder.record(Record{
+		Dsn:      RedactDsn(e.Dsn()),
+		Kind:     kind,
+		Query:    query,
+		Rows:     recordRows(rows, err),
+		Warnings: recordWarnings(warnings),
+		Error:    recordError(err),
+	})
+}
+
+func (t *recordingTransaction) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t *recordingTransaction) Rollback() error {
+	return t.tx.Rollback()
+}
+
+func (c *recordingConn) Close() error {
+	return c.conn.Close()
+}
+
+func (s *recordingStatement) QueryContext(ctx context.Context, args ...interface{}) (rows Rows, err error) {
+	rows, err = s.stmt.QueryContext(ctx, args...)
+	s.recorder.record(Record{
+		Dsn:   s.dsn,
+		Kind:  RecordPreparedQuery,
+		Query: s.query,
+		Args:  encodeArgs(args),
+		Rows:  recordRows(rows, err),
+		Error: recordError(err),
+	})
+	return
+}
+
+func (s *recordingStatement) LastPlanFromCache() (fromCache bool, err error) {
+	fromCache, err = s.stmt.LastPlanFromCache()
+	s.recorder.record(Record{Dsn: s.dsn, Kind: RecordPlanFromCache, Query: s.query, FromCache: fromCache, Error: recordError(err)})
+	return
+}
+
+func (s *recordingStatement) Close() error {
+	return s.stmt.Close()
+}
+
+func encodeArgs(args []interface{}) string {
+	if len(args) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(args)
+	if err != nil {
+		return fmt.Sprintf("%v", args)
+	}
+	return string(data)
+}
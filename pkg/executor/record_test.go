@@ -0,0 +1,111 @@
+package executor
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const records = `
+{"dsn":"main","kind":"query","query":"SELECT a FROM t","rows":{"columns":["a"],"types":["DOUBLE"],"data":[["MQ=="],[null]]}}
+{"dsn":"main","kind":"query","query":"SELECT a FROM t","error":{"message":"statement interrupted","timeout":true}}
+{"dsn":"main","kind":"explain","query":"SELECT /*+ NTH_PLAN(9) */ a FROM t","rows":{"columns":["id"],"types":[""],"data":[]},"warnings":[{"number":1105,"message":"The parameter of nth_plan() is out of range."}]}
+{"dsn":"main","kind":"prepare","query":"SELECT a FROM t WHERE a > ?"}
+{"dsn":"main","kind":"prepared_query","query":"SELECT a FROM t WHERE a > ?","args":"[1]","rows":{"columns":["a"],"types":["DOUBLE"],"data":[["Mg=="]]}}
+{"dsn":"main","kind":"plan_from_cache","query":"SELECT a FROM t WHERE a > ?","fromCache":true}
+{"dsn":"other","kind":"exec","query":"DELETE FROM t","result":{"LastInsertId":0,"RowsAffected":2}}
+{"dsn":"root@tcp(localhost:4000)/test","kind":"query","query":"SELECT 1","rows":{"columns":["1"],"types":["BIGINT"],"data":[["MQ=="]]}}
+`
+
+func TestReplay(t *testing.T) {
+	replay, err := NewReplay(strings.NewReader(records))
+	require.Nil(t, err)
+	exec := replay.Pool("main").Executor()
+
+	rows, err := exec.Query("SELECT a FROM t")
+	require.Nil(t, err)
+	assert.Equal(t, []Row{{[]byte("1")}, {nil}}, rows.Data)
+	assert.Equal(t, []valueKind{kindApprox}, rows.kinds())
+	_, err = exec.Query("SELECT a FROM t")
+	assert.True(t, IsTimeout(err))
+	// the last response is repeated
+	_, err = exec.Query("SELECT a FROM t")
+	assert.True(t, IsTimeout(err))
+
+	_, warnings, err := exec.Explain("SELECT /*+ NTH_PLAN(9) */ a FROM t")
+	require.Nil(t, err)
+	require.Len(t, warnings, 1)
+	assert.True(t, PlanOutOfRange(warnings[0]))
+
+	stmt, err := exec.Prepare("SELECT a FROM t WHERE a > ?")
+	require.Nil(t, err)
+	rows, err = stmt.QueryContext(context.Background(), int64(1))
+	require.Nil(t, err)
+	assert.Equal(t, []Row{{[]byte("2")}}, rows.Data)
+	_, err = stmt.QueryContext(context.Background(), int64(2))
+	assert.NotNil(t, err)
+	fromCache, err := stmt.LastPlanFromCache()
+	require.Nil(t, err)
+	assert.True(t, fromCache)
+
+	_, err = exec.Exec("DELETE FROM t")
+	assert.NotNil(t, err)
+	result, err := replay.Pool("other").Executor().Exec("DELETE FROM t")
+	require.Nil(t, err)
+	assert.Equal(t, int64(2), result.RowsAffected)
+
+	// records are matched regardless of the password
+	rows, err = replay.Pool("root:secret@tcp(localhost:4000)/test").Executor().Query("SELECT 1")
+	require.Nil(t, err)
+	assert.Len(t, rows.Data, 1)
+}
+
+func TestRecorder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "record")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	replay, err := NewReplay(strings.NewReader(records))
+	require.Nil(t, err)
+	file := path.Join(dir, "records.jsonl")
+	recorder, err := NewRecorder(file)
+	require.Nil(t, err)
+	tx, err := recorder.Pool(replay.Pool("main")).Transaction()
+	require.Nil(t, err)
+
+	stream, err := tx.QueryStream("SELECT a FROM t")
+	require.Nil(t, err)
+	rows, err := stream.drain()
+	require.Nil(t, err)
+	assert.Len(t, rows.Data, 2)
+	_, err = tx.Query("SELECT a FROM t")
+	assert.True(t, IsTimeout(err))
+	_, warnings, err := tx.Explain("SELECT /*+ NTH_PLAN(9) */ a FROM t")
+	require.Nil(t, err)
+	require.Nil(t, tx.Rollback())
+	_, err = recorder.Pool(replay.Pool("root:secret@tcp(localhost:4000)/test")).Executor().Query("SELECT 1")
+	require.Nil(t, err)
+	require.Nil(t, recorder.Close())
+	data, err := ioutil.ReadFile(file)
+	require.Nil(t, err)
+	assert.NotContains(t, string(data), "secret")
+
+	recorded, err := LoadReplay(file)
+	require.Nil(t, err)
+	exec := recorded.Pool("main").Executor()
+	replayed, err := exec.Query("SELECT a FROM t")
+	require.Nil(t, err)
+	assert.Equal(t, rows.Data, replayed.Data)
+	assert.Equal(t, rows.kinds(), replayed.kinds())
+	_, err = exec.Query("SELECT a FROM t")
+	assert.True(t, IsTimeout(err))
+	_, replayedWarnings, err := exec.Explain("SELECT /*+ NTH_PLAN(9) */ a FROM t")
+	require.Nil(t, err)
+	assert.Equal(t, warnings, replayedWarnings)
+}
@@ -0,0 +1,281 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package executor
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+type (
+	// Replay answers statements from records deterministically: responses of the same statement are returned
+	// in the recorded order, and the last one is repeated once they run out
+	Replay struct {
+		mu      sync.Mutex
+		records map[string][]*Record
+		cursors map[string]int
+	}
+
+	replayPool struct {
+		dsn    string
+		replay *Replay
+	}
+
+	replayExecutor struct {
+		dsn    string
+		replay *Replay
+	}
+
+	replayTransaction struct {
+		replayExecutor
+	}
+
+	replayConn struct {
+		replayExecutor
+	}
+
+	replayStatement struct {
+		dsn    string
+		query  string
+		replay *Replay
+	}
+)
+
+// LoadReplay reads records written by a Recorder
+func LoadReplay(path string) (*Replay, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return NewReplay(file)
+}
+
+func NewReplay(reader io.Reader) (*Replay, error) {
+	replay := &Replay{records: make(map[string][]*Record), cursors: make(map[string]int)}
+	decoder := json.NewDecoder(bufio.NewReader(reader))
+	for {
+		record := new(Record)
+		err := decoder.Decode(record)
+		if err == io.EOF {
+			return replay, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid record: %v", err)
+		}
+		key := recordKey(record.Dsn, record.Kind, record.Query, record.Args)
+		replay.records[key] = append(replay.records[key], record)
+	}
+}
+
+// Pool answers statements on dsn from the records
+func (r *Replay) Pool(dsn string) Pool {
+	return &replayPool{dsn: dsn, replay: r}
+}
+
+// recordKey matches records by the redacted dsn, so that a replay needs no password
+func recordKey(dsn string, kind RecordKind, query, args string) string {
+	return fmt.Sprintf("%s\x00%s\x00%s\x00%s", RedactDsn(dsn), kind, query, args)
+}
+
+func (r *Replay) next(dsn string, kind RecordKind, query string, args ...interface{}) (*Record, error) {
+	key := recordKey(dsn, kind, query, encodeArgs(args))
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	records := r.records[key]
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no recorded %s on %s: %s", kind, RedactDsn(dsn), query)
+	}
+	cursor := r.cursors[key]
+	if cursor < len(records)-1 {
+		r.cursors[key] = cursor + 1
+	}
+	record := records[cursor]
+	return record, record.Error.replay(query)
+}
+
+func (e *RecordedError) replay(query string) error {
+	switch {
+	case e == nil:
+		return nil
+	case e.Timeout:
+		return &TimeoutError{Query: query, Cause: context.DeadlineExceeded}
+	case e.Number != 0:
+		return &mysql.MySQLError{Number: e.Number, Message: e.Message}
+	default:
+		return errors.New(e.Message)
+	}
+}
+
+func (r *RecordedRows) replay() Rows {
+	if r == nil {
+		return Rows{}
+	}
+	rows := Rows{ColumnMap: make(map[string]int), Data: r.Data, typeNames: r.Types}
+	for i, column := range r.Columns {
+		rows.Columns = append(rows.Columns, []byte(column))
+		rows.ColumnMap[column] = i
+	}
+	if rows.Data == nil {
+		rows.Data = make([]Row, 0)
+	}
+	return rows
+}
+
+func (p *replayPool) Dsn() string {
+	return p.dsn
+}
+
+func (p *replayPool) Executor() Executor {
+	return &replayExecutor{dsn: p.dsn, replay: p.replay}
+}
+
+func (p *replayPool) Transaction() (Transaction, error) {
+	return p.TransactionContext(context.Background())
+}
+
+func (p *replayPool) TransactionContext(context.Context) (Transaction, error) {
+	return &replayTransaction{replayExecutor{dsn: p.dsn, replay: p.replay}}, nil
+}
+
+func (p *replayPool) Conn(context.Context) (Conn, error) {
+	return &replayConn{replayExecutor{dsn: p.dsn, replay: p.replay}}, nil
+}
+
+func (p *replayPool) SessionVariables() map[string]string {
+	return nil
+}
+
+func (e *replayExecutor) Dsn() string {
+	return e.dsn
+}
+
+func (e *replayExecutor) Query(query string) (Rows, error) {
+	return e.QueryContext(context.Background(), query)
+}
+
+func (e *replayExecutor) QueryContext(_ context.Context, query string) (Rows, error) {
+	record, err := e.replay.next(e.dsn, RecordQuery, query)
+	if err != nil {
+		return Rows{}, err
+	}
+	return record.Rows.replay(), nil
+}
+
+func (e *replayExecutor) QueryStream(query string) (RowStream, error) {
+	return e.QueryStreamContext(context.Background(), query)
+}
+
+func (e *replayExecutor) QueryStreamContext(ctx context.Context, query string) (RowStream, error) {
+	rows, err := e.QueryContext(ctx, query)
+	if err != nil {
+		return RowStream{}, err
+	}
+	return NewRowStreamFromRows(rows), nil
+}
+
+func (e *replayExecutor) Exec(query string) (Result, error) {
+	return e.ExecContext(context.Background(), query)
+}
+
+func (e *replayExecutor) ExecContext(_ context.Context, query string) (Result, error) {
+	record, err := e.replay.next(e.dsn, RecordExec, query)
+	if err != nil || record.Result == nil {
+		return Result{}, err
+	}
+	return *record.Result, nil
+}
+
+func (e *replayExecutor) Prepare(query string) (PreparedStatement, error) {
+	if _, err := e.replay.next(e.dsn, RecordPrepare, query); err != nil {
+		return nil, err
+	}
+	return &replayStatement{dsn: e.dsn, query: query, replay: e.replay}, nil
+}
+
+func (e *replayExecutor) GetHints(query string) (Hints, error) {
+	record, err := e.replay.next(e.dsn, RecordHints, query)
+	if err != nil {
+		return Hints{}, err
+	}
+	return NewHints(record.Hints), nil
+}
+
+func (e *replayExecutor) Explain(query string) (Rows, []error, error) {
+	return e.ExplainContext(context.Background(), query)
+}
+
+func (e *replayExecutor) ExplainContext(_ context.Context, query string) (Rows, []error, error) {
+	return e.explain(RecordExplain, query)
+}
+
+func (e *replayExecutor) ExplainAnalyze(query string) (Rows, []error, error) {
+	return e.ExplainAnalyzeContext(context.Background(), query)
+}
+
+func (e *replayExecutor) ExplainAnalyzeContext(_ context.Context, query string) (Rows, []error, error) {
+	return e.explain(RecordExplainAnalyze, query)
+}
+
+func (e *replayExecutor) explain(kind RecordKind, query string) (rows Rows, warnings []error, err error) {
+	record, err := e.replay.next(e.dsn, kind, query)
+	if err != nil {
+		return
+	}
+	warnings = make([]error, 0, len(record.Warnings))
+	for i := range record.Warnings {
+		warnings = append(warnings, record.Warnings[i].replay(query))
+	}
+	return record.Rows.replay(), warnings, nil
+}
+
+func (t *replayTransaction) Commit() error {
+	return nil
+}
+
+func (t *replayTransaction) Rollback() error {
+	return nil
+}
+
+func (c *replayConn) Close() error {
+	return nil
+}
+
+func (s *replayStatement) QueryContext(_ context.Context, args ...interface{}) (Rows, error) {
+	record, err := s.replay.next(s.dsn, RecordPreparedQuery, s.query, args...)
+	if err != nil {
+		return Rows{}, err
+	}
+	return record.Rows.replay(), nil
+}
+
+func (s *replayStatement) LastPlanFromCache() (bool, error) {
+	record, err := s.replay.next(s.dsn, RecordPlanFromCache, s.query)
+	if err != nil {
+		return false, err
+	}
+	return record.FromCache, nil
+}
+
+func (s *replayStatement) Close() error {
+	return nil
+}
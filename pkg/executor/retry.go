@@ -0,0 +1,45 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package executor
+
+import "time"
+
+// RetryPolicy retries statements failed with transient errors, the backoff doubles after each retry
+type RetryPolicy struct {
+	// MaxRetries is the max count of retries, zero means no retry
+	MaxRetries int
+	Backoff    time.Duration
+	// MaxBackoff caps the backoff, zero means no limit
+	MaxBackoff time.Duration
+}
+
+// Retryable reports whether err should be retried after `retries` retries
+func (p RetryPolicy) Retryable(err error, retries int) bool {
+	return retries < p.MaxRetries && Classify(err).Transient()
+}
+
+// Delay returns the backoff before the retry after `retries` retries
+func (p RetryPolicy) Delay(retries int) time.Duration {
+	delay := p.Backoff
+	for i := 0; i < retries; i++ {
+		if p.MaxBackoff != 0 && delay >= p.MaxBackoff {
+			break
+		}
+		delay *= 2
+	}
+	if p.MaxBackoff != 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	return delay
+}
@@ -14,6 +14,7 @@
 package executor
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 
@@ -24,18 +25,41 @@ type (
 	Row [][]byte
 
 	Rows struct {
-		ColumnMap map[string]int
-		Columns   Row
-		Data      []Row
+		ColumnMap   map[string]int
+		Columns     Row
+		ColumnTypes []*sql.ColumnType
+		Data        []Row
+		// typeNames stand in for ColumnTypes of rows not read from a database, like replayed ones
+		typeNames []string
 	}
 
 	RowStream struct {
-		ColumnsMap map[string]int
-		Columns    Row
-		rawStream  *sql.Rows
+		ColumnsMap  map[string]int
+		Columns     Row
+		ColumnTypes []*sql.ColumnType
+		rawStream   *sql.Rows
+		// buffer is the source of a stream without rawStream
+		buffer    []Row
+		typeNames []string
+
+		// set by QueryStreamContext
+		ctx     context.Context
+		query   string
+		release func()
 	}
 )
 
+// NewRowStreamFromRows streams rows in memory
+func NewRowStreamFromRows(rows Rows) RowStream {
+	return RowStream{
+		ColumnsMap:  rows.ColumnMap,
+		Columns:     rows.Columns,
+		ColumnTypes: rows.ColumnTypes,
+		buffer:      rows.Data,
+		typeNames:   rows.typeNames,
+	}
+}
+
 func NewRowStream(rows *sql.Rows) (ret RowStream, err error) {
 	ret.rawStream = rows
 	ret.ColumnsMap = make(map[string]int)
@@ -47,11 +71,21 @@ func NewRowStream(rows *sql.Rows) (ret RowStream, err error) {
 		ret.Columns = append(ret.Columns, []byte(column))
 		ret.ColumnsMap[column] = i
 	}
+	ret.ColumnTypes, err = rows.ColumnTypes()
 	return
 }
 
 func (s *RowStream) Next() (row Row, err error) {
+	if s.rawStream == nil {
+		if len(s.buffer) == 0 {
+			err = s.Close()
+			return
+		}
+		row, s.buffer = s.buffer[0], s.buffer[1:]
+		return
+	}
 	if !s.rawStream.Next() {
+		err = s.Close()
 		return
 	}
 
@@ -64,6 +98,7 @@ func (s *RowStream) Next() (row Row, err error) {
 
 	err = s.rawStream.Scan(dataSet...)
 	if err != nil {
+		s.Close()
 		return
 	}
 
@@ -73,6 +108,26 @@ func (s *RowStream) Next() (row Row, err error) {
 	return
 }
 
+// Close closes the underlying rows and releases the connection of the stream, it is called automatically
+// after the last row or an error
+func (s *RowStream) Close() (err error) {
+	if s.rawStream != nil {
+		err = s.rawStream.Close()
+		if err == nil {
+			err = s.rawStream.Err()
+		}
+	}
+	s.buffer = nil
+	if s.release != nil {
+		s.release()
+		s.release = nil
+	}
+	if s.ctx != nil {
+		err = interrupted(s.ctx, s.query, err)
+	}
+	return
+}
+
 func (s *RowStream) NextBatch(size uint) (rows []Row, err error) {
 	if size == 0 {
 		err = fmt.Errorf("batch size cannot be zero")
@@ -105,7 +160,7 @@ func NewRows(rows *sql.Rows) (ret Rows, err error) {
 		}
 
 		if row == nil {
-			ret = Rows{Data: data, Columns: stream.Columns, ColumnMap: stream.ColumnsMap}
+			ret = Rows{Data: data, Columns: stream.Columns, ColumnTypes: stream.ColumnTypes, ColumnMap: stream.ColumnsMap}
 			return
 		}
 
@@ -113,6 +168,33 @@ func NewRows(rows *sql.Rows) (ret Rows, err error) {
 	}
 }
 
+func (s *RowStream) databaseTypes() []string {
+	if s.ColumnTypes != nil {
+		return databaseTypeNames(s.ColumnTypes)
+	}
+	return s.typeNames
+}
+
+// drain reads the rest of the stream into rows
+func (s *RowStream) drain() (rows Rows, err error) {
+	rows = Rows{ColumnMap: s.ColumnsMap, Columns: s.Columns, ColumnTypes: s.ColumnTypes, typeNames: s.typeNames, Data: make([]Row, 0)}
+	for {
+		var row Row
+		row, err = s.Next()
+		if err != nil || row == nil {
+			return
+		}
+		rows.Data = append(rows.Data, row)
+	}
+}
+
+func (r Rows) databaseTypes() []string {
+	if r.ColumnTypes != nil {
+		return databaseTypeNames(r.ColumnTypes)
+	}
+	return r.typeNames
+}
+
 func (r Rows) RowCount() int {
 	return len(r.Data)
 }
@@ -141,24 +223,17 @@ func (r Row) ToTableRow() table.Row {
 	return tableRow
 }
 
+// Equal compares rows in order, values are compared by their column types
 func (r Rows) Equal(other Comparable) bool {
-	otherRows, ok := other.(Rows)
-
-	if !ok {
-		return false
-	}
+	otherRows, ok := rowsOf(other)
 
-	if r.RowCount() != otherRows.RowCount() || r.ColumnNums() != otherRows.ColumnNums() {
+	if !ok || !r.sameShape(otherRows) {
 		return false
 	}
 
-	for i, column := range r.Columns {
-		if len(column) != len(otherRows.Columns[i]) && string(column) != string(otherRows.Columns[i]) {
-			return false
-		}
-	}
+	kinds, options := r.kinds(), CompareOptions{}
 	for i, row := range r.Data {
-		if !row.Equal(otherRows.Data[i]) {
+		if options.compareRow(kinds, row, otherRows.Data[i]) != 0 {
 			return false
 		}
 	}
@@ -0,0 +1,117 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package executor
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var versionRegex = regexp.MustCompile(`^(\d+)\.(\d+)\.(\d+)`)
+
+// ServerStartTime derives the time the server started from its uptime, it moves forward once the server restarts
+func ServerStartTime(exec Executor) (start time.Time, err error) {
+	rows, err := exec.Query("SHOW GLOBAL STATUS LIKE 'Uptime'")
+	if err != nil {
+		return
+	}
+	if rows.RowCount() != 1 || rows.ColumnNums() != 2 {
+		err = fmt.Errorf("unexpected uptime: %#v", rows)
+		return
+	}
+	uptime, err := strconv.ParseInt(string(rows.Data[0][1]), 10, 64)
+	if err != nil {
+		return
+	}
+	return time.Now().Add(-time.Duration(uptime) * time.Second), nil
+}
+
+// ServerInstance identifies a run of a server, so that a restart is not confused with another server
+// behind a load balancer
+type ServerInstance struct {
+	Host  string
+	Start time.Time
+}
+
+// GetServerInstance gets the instance by `@@hostname` and the uptime, conn must run both statements
+// on the same connection to reach the same server
+func GetServerInstance(conn Executor) (instance ServerInstance, err error) {
+	rows, err := conn.Query("SELECT @@hostname")
+	if err != nil {
+		return
+	}
+	if rows.RowCount() != 1 || rows.ColumnNums() != 1 {
+		err = fmt.Errorf("unexpected hostname: %#v", rows)
+		return
+	}
+	instance.Host = string(rows.Data[0][0])
+	instance.Start, err = ServerStartTime(conn)
+	return
+}
+
+// RestartedAfter reports whether the instance is the same server as the earlier one started later than it by tolerance
+func (i ServerInstance) RestartedAfter(earlier ServerInstance, tolerance time.Duration) bool {
+	return i.Host == earlier.Host && i.Start.Sub(earlier.Start) > tolerance
+}
+
+// serverFlavor tells servers explaining plans in the tree format by `SELECT VERSION()`, it is detected once per pool
+type serverFlavor struct {
+	mu       sync.Mutex
+	detected bool
+	tree     bool
+}
+
+// explainsTree detects the flavor on exec if it is not detected yet, failures are taken as TiDB and detected again later
+func (f *serverFlavor) explainsTree(ctx context.Context, exec RawExecutor) bool {
+	if f == nil {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.detected {
+		data, err := exec.QueryContext(ctx, "SELECT VERSION()")
+		if err != nil {
+			return false
+		}
+		rows, err := NewRows(data)
+		if err != nil || rows.RowCount() != 1 || rows.ColumnNums() != 1 {
+			return false
+		}
+		f.detected, f.tree = true, treeExplainVersion(string(rows.Data[0][0]))
+	}
+	return f.tree
+}
+
+// treeExplainVersion reports whether the server supports `EXPLAIN FORMAT=TREE`, which is MySQL since 8.0.16.
+// TiDB looks like `5.7.25-TiDB-v4.0.6` and MariaDB like `10.5.8-MariaDB`, both explain plans in the tabular format
+func treeExplainVersion(version string) bool {
+	if strings.Contains(version, "TiDB") || strings.Contains(strings.ToLower(version), "mariadb") {
+		return false
+	}
+	matches := versionRegex.FindStringSubmatch(version)
+	if matches == nil {
+		return false
+	}
+	var numbers [3]int
+	for i := range numbers {
+		numbers[i], _ = strconv.Atoi(matches[i+1])
+	}
+	major, minor, patch := numbers[0], numbers[1], numbers[2]
+	return major > 8 || (major == 8 && (minor > 0 || patch >= 16))
+}
@@ -0,0 +1,29 @@
+package executor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTreeExplainVersion(t *testing.T) {
+	for _, testCase := range []struct {
+		version string
+		tree    bool
+	}{
+		{"5.7.25-TiDB-v4.0.6", false},
+		{"8.0.21", true},
+		{"8.0.21-0ubuntu0.20.04.4", true},
+		{"8.0.16", true},
+		{"8.0.15", false},
+		{"8.1.0", true},
+		{"5.7.31-log", false},
+		{"5.7.31-34-log", false},
+		{"8.0.22-13", true},
+		{"10.5.8-MariaDB", false},
+		{"5.5.5-10.5.8-MariaDB-1:10.5.8+maria~focal", false},
+		{"unknown", false},
+	} {
+		assert.Equal(t, testCase.tree, treeExplainVersion(testCase.version), testCase.version)
+	}
+}
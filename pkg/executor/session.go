@@ -0,0 +1,83 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package executor
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+var sessionVariableRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// withSessionVariables adds variables to the params of dsn, the driver sets them on each new connection
+func withSessionVariables(dsn string, variables map[string]string) (string, error) {
+	if len(variables) == 0 {
+		return dsn, nil
+	}
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "", err
+	}
+	if cfg.Params == nil {
+		cfg.Params = make(map[string]string)
+	}
+	for name, value := range variables {
+		if !sessionVariableRegex.MatchString(name) {
+			return "", fmt.Errorf("invalid session variable name: %s", name)
+		}
+		cfg.Params[name] = sessionValue(value)
+	}
+	return cfg.FormatDSN(), nil
+}
+
+// sessionValue quotes non-numeric values, both MySQL and TiDB accept strings like 'ON' for boolean variables
+func sessionValue(value string) string {
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return value
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
+
+// SetStatement returns the statement setting session variables, empty if there is no variable
+func SetStatement(variables map[string]string) string {
+	names := make([]string, 0, len(variables))
+	for name := range variables {
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	sort.Strings(names)
+	assignments := make([]string, 0, len(names))
+	for _, name := range names {
+		assignments = append(assignments, fmt.Sprintf("%s = %s", name, sessionValue(variables[name])))
+	}
+	return fmt.Sprintf("SET SESSION %s", strings.Join(assignments, ", "))
+}
+
+// RedactDsn drops the password from dsn, so that it can be logged and reported. A dsn the driver
+// cannot parse is returned as is, it names no connection with a password
+func RedactDsn(dsn string) string {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return dsn
+	}
+	cfg.Passwd = ""
+	return cfg.FormatDSN()
+}
@@ -0,0 +1,51 @@
+package executor
+
+import (
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithSessionVariables(t *testing.T) {
+	dsn := "root:@tcp(localhost:4000)/test?charset=utf8"
+	unchanged, err := withSessionVariables(dsn, nil)
+	require.Nil(t, err)
+	assert.Equal(t, dsn, unchanged)
+
+	sessionDsn, err := withSessionVariables(dsn, map[string]string{
+		"sql_mode":                    "ONLY_FULL_GROUP_BY,ANSI_QUOTES",
+		"tidb_mem_quota_query":        "1073741824",
+		"tidb_isolation_read_engines": "tikv, tidb",
+		"tidb_opt_agg_push_down":      "ON",
+	})
+	require.Nil(t, err)
+	cfg, err := mysql.ParseDSN(sessionDsn)
+	require.Nil(t, err)
+	assert.Equal(t, map[string]string{
+		"charset":                     "utf8",
+		"sql_mode":                    "'ONLY_FULL_GROUP_BY,ANSI_QUOTES'",
+		"tidb_mem_quota_query":        "1073741824",
+		"tidb_isolation_read_engines": "'tikv, tidb'",
+		"tidb_opt_agg_push_down":      "'ON'",
+	}, cfg.Params)
+
+	_, err = withSessionVariables(dsn, map[string]string{"a=1; DROP": "1"})
+	assert.NotNil(t, err)
+	assert.Equal(t, `'it\'s'`, sessionValue("it's"))
+}
+
+func TestSetStatement(t *testing.T) {
+	assert.Equal(t, "", SetStatement(nil))
+	assert.Equal(t, "SET SESSION tidb_mem_quota_query = 1073741824, tidb_opt_agg_push_down = 'ON'", SetStatement(map[string]string{
+		"tidb_opt_agg_push_down": "ON",
+		"tidb_mem_quota_query":   "1073741824",
+	}))
+}
+
+func TestRedactDsn(t *testing.T) {
+	assert.Equal(t, "root@tcp(localhost:4000)/test?charset=utf8", RedactDsn("root:secret@tcp(localhost:4000)/test?charset=utf8"))
+	assert.Equal(t, "root@tcp(localhost:4000)/test", RedactDsn("root@tcp(localhost:4000)/test"))
+	assert.Equal(t, "main", RedactDsn("main"))
+}
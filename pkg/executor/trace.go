@@ -0,0 +1,70 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package executor
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// downloadTimeout bounds downloads from the status address, including reading the body
+const downloadTimeout = 5 * time.Minute
+
+var statusClient = &http.Client{Timeout: downloadTimeout}
+
+// OptimizerTrace traces the query by `TRACE` of TiDB, or by the optimizer_trace of MySQL if it is unsupported.
+// The executor must run all statements on the same connection.
+func OptimizerTrace(conn Executor, query string) (rows Rows, err error) {
+	rows, err = conn.Query(fmt.Sprintf("TRACE FORMAT = 'row' %s", query))
+	if err == nil {
+		return
+	}
+	if _, e := conn.Exec("SET SESSION optimizer_trace = 'enabled=on'"); e != nil {
+		return
+	}
+	defer conn.Exec("SET SESSION optimizer_trace = 'enabled=off'")
+	if _, err = conn.Query(fmt.Sprintf("EXPLAIN %s", query)); err != nil {
+		return
+	}
+	return conn.Query("SELECT QUERY, TRACE FROM information_schema.OPTIMIZER_TRACE")
+}
+
+// PlanReplayerDump dumps schema, stats and the plan of the query on TiDB, it returns the token of the bundle
+func PlanReplayerDump(exec Executor, query string) (token string, err error) {
+	rows, err := exec.Query(fmt.Sprintf("PLAN REPLAYER DUMP EXPLAIN %s", query))
+	if err != nil {
+		return
+	}
+	if rows.RowCount() != 1 || rows.ColumnNums() != 1 {
+		err = fmt.Errorf("unexpected plan replayer token: %#v", rows)
+		return
+	}
+	return string(rows.Data[0][0]), nil
+}
+
+// DownloadPlanReplayer writes the bundle of token served on the status address of TiDB to w
+func DownloadPlanReplayer(statusAddr, token string, w io.Writer) error {
+	resp, err := statusClient.Get(fmt.Sprintf("http://%s/plan_replayer/dump/%s", statusAddr, token))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fail to download plan replayer %s: %s", token, resp.Status)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
@@ -0,0 +1,133 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package executor
+
+import (
+	"bytes"
+	"database/sql"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+type valueKind uint8
+
+const (
+	kindBytes valueKind = iota
+	// kindExact is integers and decimals
+	kindExact
+	// kindApprox is FLOAT and DOUBLE
+	kindApprox
+	kindString
+)
+
+// CompareOptions controls how values of results are compared
+type CompareOptions struct {
+	// Epsilon is the relative tolerance of approximate values like FLOAT and DOUBLE
+	Epsilon float64 `json:"epsilon"`
+	// Collation decides how strings are compared: `*_ci` collations ignore case and trailing spaces,
+	// other non-binary collations ignore trailing spaces, empty or `binary` compares bytes
+	Collation string `json:"collation"`
+}
+
+func columnKinds(typeNames []string, columns int) []valueKind {
+	kinds := make([]valueKind, columns)
+	for i, name := range typeNames {
+		if i >= columns {
+			break
+		}
+		switch name {
+		case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "BIGINT", "YEAR", "DECIMAL":
+			kinds[i] = kindExact
+		case "FLOAT", "DOUBLE":
+			kinds[i] = kindApprox
+		case "CHAR", "VARCHAR", "TINYTEXT", "TEXT", "MEDIUMTEXT", "LONGTEXT", "ENUM", "SET":
+			kinds[i] = kindString
+		}
+	}
+	return kinds
+}
+
+// databaseTypeNames returns type names of columns, an unknown type is an empty name
+func databaseTypeNames(types []*sql.ColumnType) []string {
+	names := make([]string, 0, len(types))
+	for _, tp := range types {
+		var name string
+		if tp != nil {
+			name = tp.DatabaseTypeName()
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// compareCell returns 0 if two values are equal under options, NULL is less than any value
+func (o CompareOptions) compareCell(kind valueKind, value, other []byte) int {
+	if value == nil || other == nil {
+		switch {
+		case value == nil && other == nil:
+			return 0
+		case value == nil:
+			return -1
+		default:
+			return 1
+		}
+	}
+
+	switch kind {
+	case kindExact:
+		x, okX := new(big.Rat).SetString(string(value))
+		y, okY := new(big.Rat).SetString(string(other))
+		if okX && okY {
+			return x.Cmp(y)
+		}
+	case kindApprox:
+		x, errX := strconv.ParseFloat(string(value), 64)
+		y, errY := strconv.ParseFloat(string(other), 64)
+		if errX == nil && errY == nil {
+			if x == y || math.Abs(x-y) <= o.Epsilon*math.Max(math.Abs(x), math.Abs(y)) {
+				return 0
+			}
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	case kindString:
+		return strings.Compare(o.collate(string(value)), o.collate(string(other)))
+	}
+	return bytes.Compare(value, other)
+}
+
+func (o CompareOptions) collate(str string) string {
+	collation := strings.ToLower(o.Collation)
+	if collation == "" || collation == "binary" {
+		return str
+	}
+	str = strings.TrimRight(str, " ")
+	if strings.HasSuffix(collation, "_ci") {
+		str = strings.ToLower(str)
+	}
+	return str
+}
+
+func (o CompareOptions) compareRow(kinds []valueKind, row, other Row) int {
+	for i, value := range row {
+		if result := o.compareCell(kinds[i], value, other[i]); result != 0 {
+			return result
+		}
+	}
+	return 0
+}
@@ -0,0 +1,52 @@
+package executor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareOptions_compareCell(t *testing.T) {
+	exact, tolerant, ci := CompareOptions{}, CompareOptions{Epsilon: 1e-9}, CompareOptions{Collation: "utf8mb4_general_ci"}
+	for _, testCase := range []struct {
+		options      CompareOptions
+		kind         valueKind
+		value, other []byte
+		equal        bool
+	}{
+		{exact, kindExact, []byte("1.50"), []byte("1.5"), true},
+		{exact, kindExact, []byte("10"), []byte("10.0001"), false},
+		{exact, kindBytes, []byte("1.50"), []byte("1.5"), false},
+		{exact, kindApprox, []byte("0.30000000000000004"), []byte("0.3"), false},
+		{tolerant, kindApprox, []byte("0.30000000000000004"), []byte("0.3"), true},
+		{tolerant, kindApprox, []byte("1e10"), []byte("10000000000"), true},
+		{tolerant, kindApprox, []byte("0.31"), []byte("0.3"), false},
+		{exact, kindString, []byte("abc"), []byte("ABC "), false},
+		{ci, kindString, []byte("abc"), []byte("ABC "), true},
+		{exact, kindExact, nil, []byte(""), false},
+		{exact, kindBytes, nil, nil, true},
+	} {
+		assert.Equal(t, testCase.equal, testCase.options.compareCell(testCase.kind, testCase.value, testCase.other) == 0,
+			"%s <=> %s", testCase.value, testCase.other)
+	}
+}
+
+func TestCompareOptions_multisetEqual(t *testing.T) {
+	kinds := []valueKind{kindExact, kindApprox}
+	rows := []Row{{[]byte("1"), []byte("0.1")}, {[]byte("2"), []byte("0.30000000000000004")}}
+	other := []Row{{[]byte("2.0"), []byte("0.3")}, {[]byte("1"), []byte("0.1")}}
+	assert.False(t, CompareOptions{}.multisetEqual(kinds, rows, other))
+	assert.True(t, CompareOptions{Epsilon: 1e-9}.multisetEqual(kinds, rows, other))
+
+	// 1 and 1.0000000012 are chained by 1.0000000006 within the tolerance, but they differ
+	kinds = []valueKind{kindApprox}
+	rows = []Row{{[]byte("1")}, {[]byte("1.0000000006")}, {[]byte("1.0000000012")}}
+	other = []Row{{[]byte("1.0000000012")}, {[]byte("1.0000000006")}, {[]byte("1")}}
+	assert.True(t, CompareOptions{Epsilon: 1e-9}.multisetEqual(kinds, rows, other))
+
+	// approximate columns are sorted last
+	kinds = []valueKind{kindApprox, kindString}
+	rows = []Row{{[]byte("0.3"), []byte("b")}, {[]byte("0.30000000000000004"), []byte("a")}}
+	other = []Row{{[]byte("0.30000000000000004"), []byte("b")}, {[]byte("0.3"), []byte("a")}}
+	assert.True(t, CompareOptions{Epsilon: 1e-9}.multisetEqual(kinds, rows, other))
+}
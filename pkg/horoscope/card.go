@@ -142,7 +142,12 @@ func (c *Cardinalitor) testEMQ(ctx context.Context, tableName, columnName string
 					log.Fatalln(err)
 					return
 				}
-				cis := executor.CollectEstAndActRows(executor.NewExplainAnalyzeInfo(rows))
+				ei, err := executor.NewExplainAnalyzeInfo(rows)
+				if err != nil {
+					log.Fatalln(err)
+					return
+				}
+				cis := executor.CollectEstAndActRows(ei)
 				if len(cis) == 0 {
 					continue
 				}
@@ -221,7 +226,11 @@ func (c *Cardinalitor) testREG(ctx context.Context, tableName, columnName string
 			if err != nil {
 				return nil, err
 			}
-			cis := executor.CollectEstAndActRows(executor.NewExplainAnalyzeInfo(rows))
+			ei, err := executor.NewExplainAnalyzeInfo(rows)
+			if err != nil {
+				return nil, err
+			}
+			cis := executor.CollectEstAndActRows(ei)
 			qError := cis[0].QError
 			if qError != math.Inf(1) {
 				metrics["all"].Values = append(metrics["all"].Values, qError)
@@ -0,0 +1,141 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package horoscope
+
+import (
+	"github.com/pingcap/parser/ast"
+
+	"github.com/chaos-mesh/horoscope/pkg/executor"
+	"github.com/chaos-mesh/horoscope/pkg/utils"
+)
+
+// ResultComparator wraps results of the query with the comparison strategy decided by its AST:
+// results of queries without ORDER BY are compared as multisets,
+// others are compared in order of the ORDER BY prefix which can be mapped to result columns.
+// Rows tied at the boundaries cut by LIMIT may differ between plans, so they are not compared by values.
+// Values are compared by their column types under options.
+func ResultComparator(query ast.StmtNode, options executor.CompareOptions) func(executor.Comparable) executor.Comparable {
+	keys, ordered := orderKeys(query)
+	limit := rowLimit(query)
+	return func(result executor.Comparable) executor.Comparable {
+		rows, ok := result.(executor.Rows)
+		if !ok {
+			return result
+		}
+		if !ordered || len(keys) == 0 {
+			return executor.MultisetRows{Rows: rows, Options: options, Limit: limit}
+		}
+		return executor.PrefixOrderedRows{Rows: rows, Keys: keys, Options: options, Limit: limit}
+	}
+}
+
+// ResultDigester returns the digester of results of the query, the comparison strategy is the same as ResultComparator
+func ResultDigester(query ast.StmtNode, options executor.CompareOptions, spillDir string) executor.Digester {
+	keys, ordered := orderKeys(query)
+	return executor.Digester{Keys: keys, Ordered: ordered && len(keys) != 0, Options: options, Limit: rowLimit(query), SpillDir: spillDir}
+}
+
+// rowLimit returns the LIMIT of the query, nil if there is none
+func rowLimit(query ast.StmtNode) *executor.RowLimit {
+	stmt, ok := query.(*ast.SelectStmt)
+	if !ok || stmt.Limit == nil {
+		return nil
+	}
+	limit := &executor.RowLimit{}
+	if count, ok := constUint(stmt.Limit.Count); ok {
+		limit.Count = count
+	}
+	if stmt.Limit.Offset != nil {
+		offset, ok := constUint(stmt.Limit.Offset)
+		if !ok {
+			offset = 1
+		}
+		limit.Offset = offset
+	}
+	return limit
+}
+
+func constUint(expr ast.ExprNode) (uint64, bool) {
+	value, ok := expr.(ast.ValueExpr)
+	if !ok {
+		return 0, false
+	}
+	switch number := value.GetValue().(type) {
+	case uint64:
+		return number, true
+	case int64:
+		return uint64(number), number >= 0
+	default:
+		return 0, false
+	}
+}
+
+// orderKeys returns result columns of the longest ORDER BY prefix, ordered is false if there is no ORDER BY
+func orderKeys(query ast.StmtNode) (keys []int, ordered bool) {
+	stmt, ok := query.(*ast.SelectStmt)
+	if !ok || stmt.OrderBy == nil || stmt.Fields == nil {
+		return nil, false
+	}
+	for _, item := range stmt.OrderBy.Items {
+		index := fieldIndex(stmt.Fields.Fields, item.Expr)
+		if index < 0 {
+			break
+		}
+		keys = append(keys, index)
+	}
+	return keys, true
+}
+
+func fieldIndex(fields []*ast.SelectField, expr ast.ExprNode) int {
+	if position, ok := expr.(*ast.PositionExpr); ok {
+		if position.N < 1 || position.N > len(fields) {
+			return -1
+		}
+		for _, field := range fields[:position.N] {
+			if field.WildCard != nil {
+				return -1
+			}
+		}
+		return position.N - 1
+	}
+
+	text, err := utils.BufferOut(expr)
+	if err != nil {
+		return -1
+	}
+	column, isColumn := expr.(*ast.ColumnNameExpr)
+	for i, field := range fields {
+		// the count of columns expanded from a wildcard is unknown
+		if field.WildCard != nil {
+			return -1
+		}
+		if isColumn && column.Name.Table.L == "" && field.AsName.L == column.Name.Name.L {
+			return i
+		}
+		if fieldColumn, ok := field.Expr.(*ast.ColumnNameExpr); ok && isColumn && sameColumn(fieldColumn.Name, column.Name) {
+			return i
+		}
+		if fieldText, err := utils.BufferOut(field.Expr); err == nil && fieldText == text {
+			return i
+		}
+	}
+	return -1
+}
+
+func sameColumn(column, other *ast.ColumnName) bool {
+	if column.Name.L != other.Name.L {
+		return false
+	}
+	return column.Table.L == "" || other.Table.L == "" || column.Table.L == other.Table.L
+}
@@ -0,0 +1,122 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package horoscope
+
+import (
+	"testing"
+
+	"github.com/pingcap/parser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/chaos-mesh/horoscope/pkg/executor"
+)
+
+func rows(data ...[]string) executor.Rows {
+	result := executor.Rows{Columns: executor.Row{[]byte("a"), []byte("b")}}
+	for _, values := range data {
+		var row executor.Row
+		for _, value := range values {
+			row = append(row, []byte(value))
+		}
+		result.Data = append(result.Data, row)
+	}
+	return result
+}
+
+func TestResultComparator(t *testing.T) {
+	for _, testCase := range []struct {
+		query         string
+		origin, other executor.Rows
+		equal         bool
+	}{
+		{
+			query:  "SELECT a, b FROM t",
+			origin: rows([]string{"1", "x"}, []string{"2", "y"}),
+			other:  rows([]string{"2", "y"}, []string{"1", "x"}),
+			equal:  true,
+		},
+		{
+			query:  "SELECT a, b FROM t",
+			origin: rows([]string{"1", "x"}, []string{"1", "x"}),
+			other:  rows([]string{"1", "x"}, []string{"2", "y"}),
+			equal:  false,
+		},
+		{
+			query:  "SELECT a, b FROM t ORDER BY a",
+			origin: rows([]string{"1", "x"}, []string{"1", "y"}, []string{"2", "z"}),
+			other:  rows([]string{"1", "y"}, []string{"1", "x"}, []string{"2", "z"}),
+			equal:  true,
+		},
+		{
+			query:  "SELECT a, b FROM t ORDER BY t.a DESC",
+			origin: rows([]string{"2", "z"}, []string{"1", "x"}),
+			other:  rows([]string{"1", "x"}, []string{"2", "z"}),
+			equal:  false,
+		},
+		{
+			query:  "SELECT a AS c, b FROM t ORDER BY c, 2",
+			origin: rows([]string{"1", "x"}, []string{"1", "y"}),
+			other:  rows([]string{"1", "y"}, []string{"1", "x"}),
+			equal:  false,
+		},
+		{
+			query:  "SELECT * FROM t ORDER BY a",
+			origin: rows([]string{"1", "x"}, []string{"2", "y"}),
+			other:  rows([]string{"2", "y"}, []string{"1", "x"}),
+			equal:  true,
+		},
+		{
+			query:  "SELECT a, b FROM t LIMIT 2",
+			origin: rows([]string{"1", "x"}, []string{"2", "y"}),
+			other:  rows([]string{"3", "z"}, []string{"1", "x"}),
+			equal:  true,
+		},
+		{
+			query:  "SELECT a, b FROM t LIMIT 3",
+			origin: rows([]string{"1", "x"}, []string{"2", "y"}),
+			other:  rows([]string{"1", "x"}, []string{"3", "z"}),
+			equal:  false,
+		},
+		{
+			query:  "SELECT a, b FROM t ORDER BY a LIMIT 3",
+			origin: rows([]string{"1", "x"}, []string{"2", "y"}, []string{"2", "z"}),
+			other:  rows([]string{"1", "x"}, []string{"2", "w"}, []string{"2", "z"}),
+			equal:  true,
+		},
+		{
+			query:  "SELECT a, b FROM t ORDER BY a LIMIT 3",
+			origin: rows([]string{"1", "x"}, []string{"2", "y"}, []string{"2", "z"}),
+			other:  rows([]string{"1", "w"}, []string{"2", "y"}, []string{"2", "z"}),
+			equal:  false,
+		},
+		{
+			query:  "SELECT a, b FROM t ORDER BY a LIMIT 3",
+			origin: rows([]string{"1", "x"}, []string{"2", "y"}, []string{"2", "z"}),
+			other:  rows([]string{"1", "x"}, []string{"2", "y"}, []string{"3", "z"}),
+			equal:  false,
+		},
+		{
+			query:  "SELECT a, b FROM t ORDER BY a LIMIT 1, 3",
+			origin: rows([]string{"1", "x"}, []string{"2", "y"}, []string{"3", "z"}),
+			other:  rows([]string{"1", "w"}, []string{"2", "y"}, []string{"3", "v"}),
+			equal:  true,
+		},
+	} {
+		stmt, err := parser.New().ParseOneStmt(testCase.query, "", "")
+		require.Nil(t, err)
+		compare := ResultComparator(stmt, executor.CompareOptions{})
+		assert.Equal(t, testCase.equal, compare(testCase.origin).Equal(compare(testCase.other)), testCase.query)
+	}
+}
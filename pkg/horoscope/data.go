@@ -16,6 +16,7 @@ package horoscope
 import (
 	"fmt"
 	"math"
+	"time"
 
 	"github.com/aclements/go-moremath/stats"
 	"github.com/pingcap/parser/ast"
@@ -26,27 +27,189 @@ import (
 
 type Benches struct {
 	VerifiedFail bool
-	QueryID      string
-	Query        ast.StmtNode
-	Type         QueryType
-	Round        uint
-	DefaultPlan  Bench
-	Plans        []*Bench
+	// Mismatch is the first result difference found in verification
+	Mismatch    *Mismatch
+	QueryID     string
+	Query       ast.StmtNode
+	Type        QueryType
+	Round       uint
+	DefaultPlan Bench
+	// Plans only keeps one representative for each plan digest
+	Plans []*Bench
+	// RawPlanCount is the count of nth_plans before deduplication
+	RawPlanCount int
+	// Prepared is the default plan executed as a prepared statement, nil if it is disabled
+	Prepared *PreparedBench
+	// Retries are failed attempts of the query before the last one
+	Retries []Retry
+	// Crashes are plans which crashed the server
+	Crashes []Crash
+	// Capture is the optimizer evidence of a better plan, nil if it is not captured
+	Capture *Capture
+	// Judge decides whether a plan is better than the default plan, nil means DefaultJudge
+	Judge Judge
+}
+
+// Verdict judges the plan against the default plan by the judge of the benches
+func (b *Benches) Verdict(plan *Bench) Verdict {
+	judge := b.Judge
+	if judge == nil {
+		judge = DefaultJudge
+	}
+	return judgePlan(judge, &b.DefaultPlan, plan)
 }
 
 type Bench struct {
 	Plan        uint64
 	SQL         string
 	Hints       executor.Hints
-	Explanation executor.Rows
+	Explanation *executor.PlanTree
+	Digest      string
 	Cost        *Metrics
+	// Server is the server-side metrics of each execution, nil if they are not collected
+	Server *ServerMetrics
+	// TimedOut means the plan was interrupted by the plan timeout, so Cost is nil
+	TimedOut bool
+	// Censored means the plan was interrupted by the timeout derived from the default plan,
+	// Cost only holds the timeout as a lower bound
+	Censored bool
+	// Crashed means the plan crashed the server, so Cost is nil
+	Crashed bool
+	// Analysis is the EXPLAIN ANALYZE result, only collected with cardinality estimation error
+	Analysis *executor.ExplainAnalyzeInfo
 	// use q-error to calc the cardinality error
 	BaseTableCardInfo []*executor.CardinalityInfo
 	JoinTableCardInfo []*executor.CardinalityInfo
 }
 
+// Mismatch is a result of Source differs from the result of the default plan
+type Mismatch struct {
+	// Source is the mismatched plan, like `plan(3)`, or the differential DSN
+	Source string
+	SQL    string
+	Diff   executor.ResultDiff
+}
+
+// PreparedBench is the query executed as a prepared statement with several parameter sets
+type PreparedBench struct {
+	SQL        string
+	Executions []PreparedExecution
+}
+
+// Crash is a plan which lost the connection and the server restarted after that
+type Crash struct {
+	Plan  uint64
+	SQL   string
+	Hints executor.Hints
+	Err   string
+	// Restart is the time the server started again
+	Restart time.Time
+}
+
+// Capture keeps files of optimizer traces and the plan replayer bundle in Dir
+type Capture struct {
+	Dir   string
+	Files []string
+}
+
+// Retry is an attempt of the query failed with a transient error
+type Retry struct {
+	Class   executor.ErrorClass `json:"class"`
+	Err     string              `json:"err"`
+	Backoff time.Duration       `json:"backoff"`
+}
+
+func (r Retry) String() string {
+	return fmt.Sprintf("%s(%v)", r.Class, r.Backoff)
+}
+
+type PreparedExecution struct {
+	Params []interface{}
+	// PlanFromCache means the execution used a plan from the plan cache
+	PlanFromCache bool
+}
+
+// CacheHits counts executions using cached plans
+func (b *PreparedBench) CacheHits() (hits int) {
+	for _, execution := range b.Executions {
+		if execution.PlanFromCache {
+			hits++
+		}
+	}
+	return
+}
+
 type Metrics benchstat.Metrics
 
+// OutlierPolicy decides samples of costs rejected before statistics are computed, rejected samples are
+// kept in Values but not in RValues
+type OutlierPolicy uint8
+
+const (
+	// OutlierIQR rejects samples out of [Q1 - 1.5×IQR, Q3 + 1.5×IQR]
+	OutlierIQR OutlierPolicy = iota
+	// OutlierMAD rejects samples farther than 3 scaled MADs from the median, nothing is rejected if MAD is zero
+	OutlierMAD
+	// OutlierNone keeps all samples
+	OutlierNone
+)
+
+// madScale makes MAD a consistent estimator of the standard deviation of normal distributions
+const madScale = 1.4826
+
+// ServerMetrics are taken from EXPLAIN ANALYZE of each round
+type ServerMetrics struct {
+	// Latency is the execution time of the root operator in ms
+	Latency       *Metrics
+	ProcessedKeys *Metrics
+	// Memory is the memory tracked by operators in bytes
+	Memory *Metrics
+	// CPUTime is the processing time of coprocessors in ms
+	CPUTime *Metrics
+}
+
+func newServerMetrics(stats []executor.ServerStats, policy OutlierPolicy) *ServerMetrics {
+	metrics := &ServerMetrics{
+		Latency:       &Metrics{Unit: "ms"},
+		ProcessedKeys: &Metrics{Unit: "keys"},
+		Memory:        &Metrics{Unit: "bytes"},
+		CPUTime:       &Metrics{Unit: "ms"},
+	}
+	for _, s := range stats {
+		metrics.Latency.Values = append(metrics.Latency.Values, milliseconds(s.Latency))
+		metrics.ProcessedKeys.Values = append(metrics.ProcessedKeys.Values, float64(s.ProcessedKeys))
+		metrics.Memory.Values = append(metrics.Memory.Values, float64(s.Memory))
+		metrics.CPUTime.Values = append(metrics.CPUTime.Values, milliseconds(s.CPUTime))
+	}
+	for _, m := range []*Metrics{metrics.Latency, metrics.ProcessedKeys, metrics.Memory, metrics.CPUTime} {
+		m.computeStats(policy)
+	}
+	return metrics
+}
+
+func (m *ServerMetrics) String() string {
+	if m == nil {
+		return ""
+	}
+	return fmt.Sprintf("%.3fms, keys: %.0f, mem: %.0f bytes, cpu: %.3fms",
+		m.Latency.Mean, m.ProcessedKeys.Mean, m.Memory.Mean, m.CPUTime.Mean)
+}
+
+// milliseconds keeps the sub-millisecond part of d
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// censoredMetrics is the lower bound cost of a plan interrupted after timeout
+func censoredMetrics(timeout time.Duration) *Metrics {
+	costs := Metrics(benchstat.Metrics{
+		Unit:   "ms",
+		Values: []float64{milliseconds(timeout)},
+	})
+	costs.computeStats(OutlierNone)
+	return &costs
+}
+
 func (m *Metrics) format() string {
 	mean, diff := m.Mean, m.Diff()
 	return fmt.Sprintf("%.1fms ± %.1f%%", mean, diff*100)
@@ -62,16 +225,30 @@ func (m *Metrics) Diff() float64 {
 }
 
 // computeStats updates the derived statistics in d from the raw
-// samples in d.Values.
-func (m *Metrics) computeStats() {
+// samples in d.Values, samples rejected by policy are excluded from RValues and Mean.
+func (m *Metrics) computeStats(policy OutlierPolicy) {
 	var value []float64
 	var rValue []float64
 	for _, v := range m.Values {
 		value = append(value, v)
 	}
 	values := stats.Sample{Xs: value}
-	q1, q3 := values.Quantile(0.25), values.Quantile(0.75)
-	lo, hi := q1-1.5*(q3-q1), q3+1.5*(q3-q1)
+	lo, hi := math.Inf(-1), math.Inf(1)
+	switch policy {
+	case OutlierIQR:
+		q1, q3 := values.Quantile(0.25), values.Quantile(0.75)
+		lo, hi = q1-1.5*(q3-q1), q3+1.5*(q3-q1)
+	case OutlierMAD:
+		median := values.Quantile(0.5)
+		deviations := make([]float64, 0, len(value))
+		for _, v := range value {
+			deviations = append(deviations, math.Abs(v-median))
+		}
+		if mad := (stats.Sample{Xs: deviations}).Quantile(0.5); mad != 0 {
+			lo, hi = median-3*madScale*mad, median+3*madScale*mad
+		}
+	}
+	m.RValues = nil
 	for _, value := range value {
 		if lo <= value && value <= hi {
 			rValue = append(rValue, value)
@@ -82,6 +259,22 @@ func (m *Metrics) computeStats() {
 	m.Mean = stats.Mean(rValue)
 }
 
+// Rejected returns samples in Values but not in RValues
+func (m *Metrics) Rejected() (rejected []float64) {
+	kept := make(map[float64]int, len(m.RValues))
+	for _, v := range m.RValues {
+		kept[v]++
+	}
+	for _, v := range m.Values {
+		if kept[v] > 0 {
+			kept[v]--
+			continue
+		}
+		rejected = append(rejected, v)
+	}
+	return
+}
+
 func (m *Metrics) quantile(q float64) float64 {
 	values := stats.Sample{Xs: m.Values}
 	return values.Quantile(q)
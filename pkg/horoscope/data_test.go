@@ -0,0 +1,60 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package horoscope
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/chaos-mesh/horoscope/pkg/executor"
+)
+
+func TestMetrics_ComputeStats(t *testing.T) {
+	for _, testCase := range []struct {
+		name     string
+		values   []float64
+		policy   OutlierPolicy
+		rejected []float64
+		mean     float64
+	}{
+		{name: "iqr", values: []float64{10, 11, 12, 10, 11, 50}, policy: OutlierIQR, rejected: []float64{50}, mean: 10.8},
+		{name: "mad", values: []float64{10, 11, 12, 10, 11, 50}, policy: OutlierMAD, rejected: []float64{50}, mean: 10.8},
+		{name: "none", values: []float64{10, 11, 12, 10, 11, 50}, policy: OutlierNone, mean: 104.0 / 6},
+		{name: "iqr of identical samples", values: []float64{10, 10, 10, 10, 10, 10, 10, 50}, policy: OutlierIQR, rejected: []float64{50}, mean: 10},
+		{name: "zero mad", values: []float64{10, 10, 10, 10, 10, 10, 10, 50}, policy: OutlierMAD, mean: 15},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			m := &Metrics{Unit: "ms", Values: testCase.values}
+			m.computeStats(testCase.policy)
+			assert.Equal(t, testCase.rejected, m.Rejected())
+			assert.InDelta(t, testCase.mean, m.Mean, 1e-9)
+			assert.Equal(t, 10.0, m.Min)
+			assert.Equal(t, 50.0, m.Max)
+
+			// statistics are computed again from Values
+			m.computeStats(testCase.policy)
+			assert.Equal(t, len(testCase.values)-len(testCase.rejected), len(m.RValues))
+		})
+	}
+}
+
+func TestNewServerMetrics(t *testing.T) {
+	var stats []executor.ServerStats
+	for _, keys := range []int64{10, 11, 12, 10, 11, 50} {
+		stats = append(stats, executor.ServerStats{ProcessedKeys: keys})
+	}
+	assert.Equal(t, []float64{50}, newServerMetrics(stats, OutlierIQR).ProcessedKeys.Rejected())
+	assert.Empty(t, newServerMetrics(stats, OutlierNone).ProcessedKeys.Rejected())
+}
@@ -14,8 +14,15 @@
 package horoscope
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"os"
+	"path"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pingcap/parser/ast"
@@ -33,6 +40,29 @@ const (
 	DML
 )
 
+const (
+	// ParallelNone runs statements of queries one after another
+	ParallelNone Parallelism = iota
+	// ParallelVerify times rounds one at a time, plan collection and verification of other queries
+	// and differential executions run concurrently with them
+	ParallelVerify
+	// ParallelFull also runs rounds of different plans concurrently, costs are measured under contention
+	ParallelFull
+)
+
+// crashPollInterval is the interval of checking whether the server comes back after a lost connection
+const crashPollInterval = time.Second
+
+// restartTolerance absorbs the error of server start times derived from uptime in seconds
+const restartTolerance = 2 * time.Second
+
+// minRelativeTimeout is the lower bound of timeouts derived from PlanTimeoutFactor,
+// interrupting a statement too early makes the censored cost meaningless
+const minRelativeTimeout = 10 * time.Millisecond
+
+// DefaultDiffLimit caps lists of result diffs when DiffLimit is zero
+const DefaultDiffLimit = 20
+
 var (
 	PlanHint = model.NewCIStr("NTH_PLAN")
 )
@@ -42,6 +72,10 @@ type ServerError struct {
 	error
 }
 
+func (e ServerError) Unwrap() error {
+	return e.error
+}
+
 type (
 	Horoscope struct {
 		exec                   executor.Pool
@@ -49,26 +83,183 @@ type (
 		loader                 loader.QueryLoader
 		enableCollectCardError bool
 		explicitTxn            bool
+		options                Options
+		// loaderMu makes Next safe for concurrent use
+		loaderMu sync.Mutex
+		// slots bound timed executions on the main pool of all queries, nil means no bound
+		slots chan struct{}
 	}
 	QueryType uint8
+
+	// Parallelism decides which executions of a query and of concurrent queries may overlap
+	Parallelism uint8
+
+	runFunc func(exec executor.Executor, round uint, sql string, timeout time.Duration) (*Metrics, []executor.Comparable, error)
+
+	// planRun is the outcome of rounds of a plan run ahead of the verification
+	planRun struct {
+		cost *Metrics
+		sets []executor.Comparable
+		err  error
+	}
+
+	// differentialRun is the outcome of the default plan on a differential pool
+	differentialRun struct {
+		dsn     string
+		results []executor.Comparable
+		err     error
+	}
+
+	Options struct {
+		// PlanTimeout interrupts an execution of a plan running longer than it, zero means no limit
+		PlanTimeout time.Duration
+		// PlanTimeoutFactor interrupts a plan running longer than k× mean cost of the default plan,
+		// the plan is censored instead of measured, zero means disabled
+		PlanTimeoutFactor float64
+		// Compare controls how values of results are compared in verification
+		Compare executor.CompareOptions
+		// DiffLimit caps rows and cells of each list in a result diff, zero means DefaultDiffLimit
+		DiffLimit int
+		// StreamVerify folds results of queries into digests while they stream instead of keeping them in memory
+		StreamVerify bool
+		// SpillDir keeps full results of StreamVerify on disk for result diffs, empty means no spilling
+		SpillDir string
+		// PreparedParamSets executes the query as a prepared statement with that many parameter sets
+		// in verification, zero means disabled
+		PreparedParamSets int
+		// Retry tests the query again if it fails with a transient error
+		Retry executor.RetryPolicy
+		// ConnAffinity runs all statements of a query on a dedicated connection, so that rounds of plans
+		// share the same session state and caches
+		ConnAffinity bool
+		// ServerMetrics collects server-side metrics of each round by an extra EXPLAIN ANALYZE
+		ServerMetrics bool
+		// CaptureDir keeps optimizer traces and plan replayer bundles of queries with better plans
+		// in a directory per query under it, empty means disabled
+		CaptureDir string
+		// StatusAddr is the status address of TiDB to download plan replayer bundles, like `127.0.0.1:10080`,
+		// only tokens of bundles are kept if it is empty
+		StatusAddr string
+		// CrashRecovery waits the server to come back for that long after a plan lost the connection,
+		// the plan is recorded as a crash if the server restarted, zero means disabled
+		CrashRecovery time.Duration
+		// Parallelism allows Next to be called concurrently and decides which executions may overlap
+		Parallelism Parallelism
+		// Concurrency caps timed executions running concurrently on the main pool with ParallelFull, each worker of
+		// concurrent plans holds an executor of its own. It must leave connections of the pool to the executors held by
+		// callers of Next and to `KILL` statements of timeouts, zero means one worker per plan
+		Concurrency int
+		// Warmup runs each plan that many times before its timed rounds, the warmup executions are not measured
+		Warmup uint
+		// Outlier rejects samples of costs before statistics are computed
+		Outlier OutlierPolicy
+		// Judge decides whether a plan is better than the default plan, nil means DefaultJudge
+		Judge Judge
+		// MaxRounds adds rounds to plans undecided by Judge, `round` rounds a step,
+		// until they are decided or have MaxRounds rounds, zero or not greater than `round` means fixed rounds.
+		// Plans are judged by SequentialJudge of Judge then
+		MaxRounds uint
+		// Interleave runs a round of every alternative plan before the next round, so that drift of the cluster
+		// spreads over all plans instead of penalizing some of them
+		Interleave bool
+	}
 )
 
-func NewHoroscope(exec executor.Pool, differentialExecs []executor.Pool, loader loader.QueryLoader, enableCollectCardError bool) *Horoscope {
-	return &Horoscope{exec: exec, differentialExecs: differentialExecs, loader: loader, enableCollectCardError: enableCollectCardError}
+func NewHoroscope(exec executor.Pool, differentialExecs []executor.Pool, loader loader.QueryLoader, enableCollectCardError bool, options Options) *Horoscope {
+	if options.DiffLimit == 0 {
+		options.DiffLimit = DefaultDiffLimit
+	}
+	horo := &Horoscope{exec: exec, differentialExecs: differentialExecs, loader: loader, enableCollectCardError: enableCollectCardError, options: options}
+	switch options.Parallelism {
+	case ParallelVerify:
+		horo.slots = make(chan struct{}, 1)
+	case ParallelFull:
+		if options.Concurrency > 0 {
+			horo.slots = make(chan struct{}, options.Concurrency)
+		}
+	}
+	return horo
 }
 
-func (h *Horoscope) Next(round uint, maxPlans uint64, verify bool, ignoreServerError bool) (benches *Benches, err error) {
-	exec, closeFunc, err := newExecutorFromPool(h.exec, h.explicitTxn)
-	if err != nil {
-		return nil, fmt.Errorf("create an executor failed: %v", err)
+// Sessions returns session variables of the main pool and differential pools, passwords are dropped from DSNs
+func (h *Horoscope) Sessions() []Session {
+	sessions := []Session{{Dsn: executor.RedactDsn(h.exec.Dsn()), Variables: h.exec.SessionVariables()}}
+	for _, pool := range h.differentialExecs {
+		sessions = append(sessions, Session{Dsn: executor.RedactDsn(pool.Dsn()), Variables: pool.SessionVariables()})
 	}
-	defer closeFunc()
+	return sessions
+}
 
+func (h *Horoscope) Next(round uint, maxPlans uint64, verify bool, ignoreServerError bool) (benches *Benches, err error) {
+	h.loaderMu.Lock()
 	qID, query := h.loader.Next()
+	h.loaderMu.Unlock()
 	if query == nil {
 		return
 	}
 
+	restoreHints := hintsRestorer(query)
+	var retries []Retry
+	for {
+		benches, err = h.test(qID, query, round, maxPlans, verify, ignoreServerError)
+		if err == nil || (benches != nil && (benches.VerifiedFail || len(benches.Crashes) != 0)) ||
+			!h.options.Retry.Retryable(err, len(retries)) {
+			break
+		}
+		retry := Retry{Class: executor.Classify(err), Err: err.Error(), Backoff: h.options.Retry.Delay(len(retries))}
+		retries = append(retries, retry)
+		log.WithFields(log.Fields{
+			"query id": qID,
+			"class":    retry.Class,
+			"backoff":  retry.Backoff,
+			"err":      retry.Err,
+		}).Warnf("retry the query(%d/%d)", len(retries), h.options.Retry.MaxRetries)
+		time.Sleep(retry.Backoff)
+		restoreHints()
+	}
+	if benches == nil && (len(retries) != 0 || executor.IsTimeout(err)) {
+		benches = &Benches{QueryID: qID, Query: query}
+	}
+	if len(retries) != 0 {
+		benches.Retries = retries
+	}
+	if executor.IsTimeout(err) {
+		// a query is skipped once its default plan times out, it is reported as timed out instead of tested again
+		benches.DefaultPlan.TimedOut = true
+	}
+	return
+}
+
+// hintsRestorer saves table hints of the query, collectPlans sets NTH_PLAN in them
+func hintsRestorer(query ast.StmtNode) func() {
+	_, hints, err := AnalyzeQuery(query, "")
+	if err != nil {
+		return func() {}
+	}
+	original := append([]*ast.TableOptimizerHint(nil), *hints...)
+	var planID interface{}
+	if planHint := findPlanHint(original); planHint != nil {
+		planID = planHint.HintData
+	}
+	return func() {
+		*hints = append([]*ast.TableOptimizerHint(nil), original...)
+		if planHint := findPlanHint(original); planHint != nil {
+			planHint.HintData = planID
+		}
+	}
+}
+
+// test runs an attempt of the query
+func (h *Horoscope) test(qID string, query ast.StmtNode, round uint, maxPlans uint64, verify bool, ignoreServerError bool) (benches *Benches, err error) {
+	exec, closeFunc, err := h.newExecutor(h.exec)
+	if err != nil {
+		return nil, fmt.Errorf("create an executor failed: %w", err)
+	}
+	defer func() {
+		closeFunc()
+	}()
+	server := h.serverInstance()
+
 	benches, err = h.collectPlans(qID, query, maxPlans)
 	if err != nil {
 		return
@@ -77,23 +268,61 @@ func (h *Horoscope) Next(round uint, maxPlans uint64, verify bool, ignoreServerE
 		"query id":        qID,
 		"query":           benches.DefaultPlan.SQL,
 		"plan space size": len(benches.Plans),
+		"raw plan count":  benches.RawPlanCount,
 	}).Info("complete plan collection")
 
 	benches.Round = round
+	benches.Judge = h.options.Judge
+	if h.options.MaxRounds > round {
+		benches.Judge = SequentialJudge(benches.Judge, round, h.options.MaxRounds)
+	}
 
-	cost, originResultSets, err := RunSQLWithTime(exec, benches.Round, benches.DefaultPlan.SQL, benches.Type)
+	run := h.runner(query, benches.Type)
+	var differential chan []differentialRun
+	if verify && h.options.Parallelism != ParallelNone {
+		differential = make(chan []differentialRun, 1)
+		go func() {
+			differential <- h.runDifferential(run, round, benches.DefaultPlan.SQL)
+		}()
+		defer func() {
+			// results of an unfinished test are dropped once they are ready
+			if differential != nil {
+				go func(differential chan []differentialRun) {
+					for _, dRun := range <-differential {
+						removeSpills(dRun.results)
+					}
+				}(differential)
+			}
+		}()
+	}
+
+	timed := h.throttle(run)
+	h.warmup(exec, timed, &benches.DefaultPlan, h.options.PlanTimeout)
+	cost, originResultSets, err := timed(exec, benches.Round, benches.DefaultPlan.SQL, h.options.PlanTimeout)
+	defer removeSpills(originResultSets)
 	if err != nil {
+		if executor.IsTimeout(err) {
+			benches.DefaultPlan.TimedOut = true
+		}
+		if restart, crashed := h.crashed(server, err); crashed {
+			benches.DefaultPlan.Crashed = true
+			benches.Crashes = append(benches.Crashes, newCrash(&benches.DefaultPlan, err, restart.Start))
+			err = fmt.Errorf("default plan crashed the server: %w", err)
+		}
 		return
 	}
-	testOracle := originResultSets[0]
+	compare := ResultComparator(query, h.options.Compare)
+	testOracle := compare(originResultSets[0])
 
 	benches.DefaultPlan.Cost = cost
+	h.collectServerMetrics(exec, &benches.DefaultPlan, round, h.options.PlanTimeout)
 	if h.enableCollectCardError {
-		b, j, e := h.CollectCardinalityEstimationError(benches.DefaultPlan.SQL)
+		analysis, e := h.Analyze(benches.DefaultPlan.SQL)
 		if e != nil {
-			return nil, err
+			return benches, e
 		}
-		benches.DefaultPlan.BaseTableCardInfo, benches.DefaultPlan.JoinTableCardInfo = b, j
+		benches.DefaultPlan.Analysis = analysis
+		benches.DefaultPlan.BaseTableCardInfo, benches.DefaultPlan.JoinTableCardInfo = CardinalityEstimationError(analysis)
 	}
 	log.WithFields(log.Fields{
 		"query id": qID,
@@ -102,23 +331,113 @@ func (h *Horoscope) Next(round uint, maxPlans uint64, verify bool, ignoreServerE
 		"hints":    benches.DefaultPlan.Hints,
 	}).Info("complete origin query")
 
-	for _, plan := range benches.Plans {
+	if verify && h.options.PreparedParamSets > 0 && benches.Type == DQL {
+		if err = h.verifyPrepared(exec, benches, compare); err != nil {
+			if benches.VerifiedFail {
+				return
+			}
+			// the prepared statement is a secondary check, its failures keep plans of the query tested
+			log.WithFields(log.Fields{
+				"query id": qID,
+				"query":    benches.DefaultPlan.SQL,
+				"err":      err.Error(),
+			}).Warn("fail to verify the query as a prepared statement")
+			benches.Prepared, err = nil, nil
+		}
+	}
+
+	timeout, censored := h.planTimeout(benches.DefaultPlan.Cost)
+	var runs []planRun
+	if h.options.Interleave || h.options.Parallelism == ParallelFull {
+		if runs, err = h.runPlans(exec, run, benches.Plans, round, timeout); err != nil {
+			return
+		}
+		defer func() {
+			for _, r := range runs {
+				removeSpills(r.sets)
+			}
+		}()
+		for _, r := range runs {
+			if r.err == nil || !executor.Classify(r.err).Transient() {
+				continue
+			}
+			if restart, crashed := h.crashed(server, r.err); crashed {
+				closeFunc()
+				if exec, closeFunc, err = h.newExecutor(h.exec); err != nil {
+					return benches, fmt.Errorf("create an executor failed: %w", err)
+				}
+				server = restart
+			}
+			break
+		}
+	}
+	for i, plan := range benches.Plans {
 		var sets []executor.Comparable
-		cost, sets, err = RunSQLWithTime(exec, round, plan.SQL, benches.Type)
+		if runs != nil {
+			cost, sets, err = runs[i].cost, runs[i].sets, runs[i].err
+			runs[i].sets = nil
+			if err != nil && executor.Classify(err).Transient() {
+				// plans lost the connection together, rerun each alone to find out the one crashed the server
+				h.warmup(exec, timed, plan, timeout)
+				cost, sets, err = timed(exec, round, plan.SQL, timeout)
+			}
+		} else {
+			h.warmup(exec, timed, plan, timeout)
+			cost, sets, err = timed(exec, round, plan.SQL, timeout)
+		}
 		if err != nil {
-			if _, serverError := err.(ServerError); serverError && ignoreServerError {
+			if executor.IsTimeout(err) && censored {
+				plan.Censored, plan.Cost = true, censoredMetrics(timeout)
+				log.WithFields(log.Fields{
+					"query id": qID,
+					"query":    plan.SQL,
+					"timeout":  timeout,
+				}).Infof("plan%d is slower than %.1f× default plan", plan.Plan, h.options.PlanTimeoutFactor)
+				err = nil
+				continue
+			}
+			if executor.IsTimeout(err) {
+				plan.TimedOut = true
+				log.WithFields(log.Fields{
+					"query id": qID,
+					"query":    plan.SQL,
+					"timeout":  h.options.PlanTimeout,
+				}).Warnf("plan%d timed out", plan.Plan)
+				err = nil
+				continue
+			}
+			if restart, crashed := h.crashed(server, err); crashed {
+				plan.Crashed = true
+				benches.Crashes = append(benches.Crashes, newCrash(plan, err, restart.Start))
+				log.WithFields(log.Fields{
+					"query id": qID,
+					"query":    plan.SQL,
+					"hints":    plan.Hints,
+					"err":      err.Error(),
+				}).Errorf("plan%d crashed the server", plan.Plan)
+				closeFunc()
+				if exec, closeFunc, err = h.newExecutor(h.exec); err != nil {
+					return benches, fmt.Errorf("create an executor failed: %w", err)
+				}
+				server = restart
+				continue
+			}
+			// transient errors are not ignored so that the query is retried
+			if _, serverError := err.(ServerError); serverError && ignoreServerError && !executor.Classify(err).Transient() {
 				continue
 			}
 			return nil, err
 		}
 		plan.Cost = cost
+		h.collectServerMetrics(exec, plan, round, timeout)
 
 		if h.enableCollectCardError {
-			b, j, e := h.CollectCardinalityEstimationError(plan.SQL)
+			analysis, e := h.Analyze(plan.SQL)
 			if e != nil {
-				return nil, err
+				return benches, e
 			}
-			plan.BaseTableCardInfo, plan.JoinTableCardInfo = b, j
+			plan.Analysis = analysis
+			plan.BaseTableCardInfo, plan.JoinTableCardInfo = CardinalityEstimationError(analysis)
 			var baseTableQErrorStats [][]interface{}
 			var joinTableQErrorStats [][]interface{}
 			for _, c := range plan.BaseTableCardInfo {
@@ -144,43 +463,515 @@ func (h *Horoscope) Next(round uint, maxPlans uint64, verify bool, ignoreServerE
 
 		if verify {
 			for _, set := range sets {
-				if !testOracle.Equal(set) {
+				if !testOracle.Equal(compare(set)) {
 					benches.VerifiedFail = true
+					benches.Mismatch = h.mismatch(fmt.Sprintf("plan(%d)", plan.Plan), plan.SQL, testOracle, compare(set))
 					err = fmt.Errorf("results mismatch in plan(%d)", plan.Plan)
-					return
+					break
 				}
 			}
 		}
+		removeSpills(sets)
+		if err != nil {
+			return
+		}
+	}
+
+	if h.options.MaxRounds > round {
+		h.addRounds(exec, timed, benches, timeout)
 	}
 
 	if verify {
-		for _, pool := range h.differentialExecs {
-			var results []executor.Comparable
-			var dExec executor.Executor
-			var dCloseFunc func()
-			dExec, dCloseFunc, err = newExecutorFromPool(pool, h.explicitTxn)
+		var dRuns []differentialRun
+		if differential != nil {
+			dRuns, differential = <-differential, nil
+		} else {
+			dRuns = h.runDifferential(run, benches.Round, benches.DefaultPlan.SQL)
+		}
+		for _, dRun := range dRuns {
+			if err == nil && dRun.err != nil {
+				err = dRun.err
+			}
+			for _, result := range dRun.results {
+				if err == nil && !testOracle.Equal(compare(result)) {
+					benches.VerifiedFail = true
+					benches.Mismatch = h.mismatch(executor.RedactDsn(dRun.dsn), benches.DefaultPlan.SQL, testOracle, compare(result))
+					err = fmt.Errorf("results mismatch in different DSN: %s <=> %s", executor.RedactDsn(exec.Dsn()), executor.RedactDsn(dRun.dsn))
+				}
+			}
+			removeSpills(dRun.results)
+		}
+		if err != nil {
+			return
+		}
+	}
+
+	if h.options.CaptureDir != "" {
+		h.capture(benches)
+	}
+	return
+}
+
+// capture saves optimizer traces of the default plan and the best plan and the plan replayer bundle of the query
+// if there is a better plan, failures are only logged
+func (h *Horoscope) capture(benches *Benches) {
+	var best *Bench
+	for _, plan := range benches.Plans {
+		if plan.Plan != benches.DefaultPlan.Plan && benches.Verdict(plan).Better &&
+			(best == nil || plan.Cost.Mean < best.Cost.Mean) {
+			best = plan
+		}
+	}
+	if best == nil {
+		return
+	}
+
+	capture := &Capture{Dir: path.Join(h.options.CaptureDir, benches.QueryID)}
+	save := func(name string, write func(w io.Writer) error) {
+		file := path.Join(capture.Dir, name)
+		err := os.MkdirAll(capture.Dir, 0755)
+		if err == nil {
+			err = writeFile(file, write)
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"query id": benches.QueryID,
+				"file":     file,
+				"err":      err.Error(),
+			}).Warn("fail to capture optimizer evidence")
+			return
+		}
+		capture.Files = append(capture.Files, name)
+	}
+	save("query.sql", func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "-- default plan%d: %s\n%s;\n-- better plan%d: %s\n-- hints diff: %s\n%s;\n",
+			benches.DefaultPlan.Plan, benches.DefaultPlan.Cost.format(), benches.DefaultPlan.SQL,
+			best.Plan, best.Cost.format(), benches.DefaultPlan.Hints.Diff(best.Hints), best.SQL)
+		return err
+	})
+
+	// traces and the dump wait for a slot of the main pool like timed executions, the download does not
+	release := func() {}
+	if h.slots != nil {
+		h.slots <- struct{}{}
+		release = func() {
+			<-h.slots
+		}
+	}
+	conn, err := h.exec.Conn(context.Background())
+	if err != nil {
+		release()
+		log.WithFields(log.Fields{
+			"query id": benches.QueryID,
+			"err":      err.Error(),
+		}).Warn("fail to capture optimizer evidence")
+		return
+	}
+	for _, plan := range []*Bench{&benches.DefaultPlan, best} {
+		save(fmt.Sprintf("trace-plan%d.txt", plan.Plan), func(w io.Writer) error {
+			rows, err := executor.OptimizerTrace(conn, plan.SQL)
 			if err != nil {
+				return err
+			}
+			_, err = io.WriteString(w, rows.String())
+			return err
+		})
+	}
+	token, err := executor.PlanReplayerDump(conn, benches.DefaultPlan.SQL)
+	conn.Close()
+	release()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"query id": benches.QueryID,
+			"err":      err.Error(),
+		}).Warn("fail to dump plan replayer")
+	} else if h.options.StatusAddr != "" {
+		save("plan_replayer.zip", func(w io.Writer) error {
+			return executor.DownloadPlanReplayer(h.options.StatusAddr, token, w)
+		})
+	} else {
+		save("plan_replayer.token", func(w io.Writer) error {
+			_, err := io.WriteString(w, token)
+			return err
+		})
+	}
+
+	benches.Capture = capture
+	log.WithFields(log.Fields{
+		"query id": benches.QueryID,
+		"dir":      capture.Dir,
+		"files":    capture.Files,
+	}).Info("capture optimizer evidence")
+}
+
+func writeFile(name string, write func(w io.Writer) error) error {
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	err = write(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(name)
+	}
+	return err
+}
+
+// verifyPrepared executes the default plan as a prepared statement with each parameter set,
+// results are verified against the text protocol execution of the same parameters
+func (h *Horoscope) verifyPrepared(exec executor.Executor, benches *Benches, compare func(executor.Comparable) executor.Comparable) (err error) {
+	query, err := Parameterize(benches.DefaultPlan.SQL)
+	if err != nil {
+		return
+	}
+	stmt, err := exec.Prepare(query.SQL)
+	if err != nil {
+		return ServerError{err}
+	}
+	defer stmt.Close()
+
+	benches.Prepared = &PreparedBench{SQL: query.SQL}
+	for _, params := range query.ParamSets(h.options.PreparedParamSets) {
+		var sql string
+		if sql, err = query.Inline(params); err != nil {
+			return
+		}
+		var expected, actual executor.Rows
+		if expected, err = h.queryWithTimeout(func(ctx context.Context) (executor.Rows, error) {
+			return exec.QueryContext(ctx, sql)
+		}); err != nil {
+			return
+		}
+		if actual, err = h.queryWithTimeout(func(ctx context.Context) (executor.Rows, error) {
+			return stmt.QueryContext(ctx, params...)
+		}); err != nil {
+			return
+		}
+		execution := PreparedExecution{Params: params}
+		if execution.PlanFromCache, err = stmt.LastPlanFromCache(); err != nil {
+			return ServerError{err}
+		}
+		benches.Prepared.Executions = append(benches.Prepared.Executions, execution)
+
+		if oracle := compare(expected); !oracle.Equal(compare(actual)) {
+			benches.VerifiedFail = true
+			benches.Mismatch = h.mismatch(fmt.Sprintf("prepared%v", params), query.SQL, oracle, compare(actual))
+			return fmt.Errorf("results mismatch in prepared statement with parameters %v", params)
+		}
+	}
+	log.WithFields(log.Fields{
+		"query id":   benches.QueryID,
+		"query":      query.SQL,
+		"executions": len(benches.Prepared.Executions),
+		"cache hits": benches.Prepared.CacheHits(),
+	}).Info("complete prepared statement")
+	return
+}
+
+// queryWithTimeout runs query under the plan timeout, errors are handled like RunSQLWithTime
+func (h *Horoscope) queryWithTimeout(query func(ctx context.Context) (executor.Rows, error)) (rows executor.Rows, err error) {
+	ctx, cancel := context.Background(), func() {}
+	if h.options.PlanTimeout != 0 {
+		ctx, cancel = context.WithTimeout(ctx, h.options.PlanTimeout)
+	}
+	defer cancel()
+	rows, err = query(ctx)
+	if err != nil && !executor.IsTimeout(err) {
+		err = ServerError{err}
+	}
+	return
+}
+
+// serverInstance returns the server of the main pool, it is zero if crash detection is disabled or unavailable
+func (h *Horoscope) serverInstance() (instance executor.ServerInstance) {
+	if h.options.CrashRecovery == 0 {
+		return
+	}
+	instance, err := h.getServerInstance()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err.Error(),
+		}).Warn("fail to get the server instance, crashes cannot be detected")
+	}
+	return
+}
+
+func (h *Horoscope) getServerInstance() (instance executor.ServerInstance, err error) {
+	conn, err := h.exec.Conn(context.Background())
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	return executor.GetServerInstance(conn)
+}
+
+// crashed waits the server to come back after err lost the connection, it reports whether the server restarted
+// since it was the instance. Another server behind a load balancer is not taken as a restart
+func (h *Horoscope) crashed(instance executor.ServerInstance, err error) (restart executor.ServerInstance, crashed bool) {
+	if instance.Start.IsZero() || !executor.Classify(err).Transient() {
+		return
+	}
+	deadline := time.Now().Add(h.options.CrashRecovery)
+	for {
+		var e error
+		if restart, e = h.getServerInstance(); e == nil {
+			if restart.Host != instance.Host {
+				log.WithFields(log.Fields{
+					"host":    instance.Host,
+					"reached": restart.Host,
+				}).Warn("another server is reached, crashes cannot be detected")
+			}
+			return restart, restart.RestartedAfter(instance, restartTolerance)
+		}
+		if time.Now().After(deadline) {
+			log.WithFields(log.Fields{
+				"err":     e.Error(),
+				"timeout": h.options.CrashRecovery,
+			}).Warn("the server does not come back")
+			return executor.ServerInstance{}, false
+		}
+		time.Sleep(crashPollInterval)
+	}
+}
+
+func newCrash(plan *Bench, err error, restart time.Time) Crash {
+	return Crash{Plan: plan.Plan, SQL: plan.SQL, Hints: plan.Hints, Err: err.Error(), Restart: restart}
+}
+
+func (h *Horoscope) mismatch(source, sql string, expected, actual executor.Comparable) *Mismatch {
+	return &Mismatch{
+		Source: source,
+		SQL:    sql,
+		Diff:   executor.Diff(expected, actual, h.options.DiffLimit),
+	}
+}
+
+// runner returns RunSQLWithDigest for DQL if StreamVerify is enabled, otherwise RunSQLWithTime,
+// statistics of costs are computed again under the outlier policy
+func (h *Horoscope) runner(query ast.StmtNode, tp QueryType) runFunc {
+	run := func(exec executor.Executor, round uint, sql string, timeout time.Duration) (*Metrics, []executor.Comparable, error) {
+		return RunSQLWithTime(exec, round, sql, tp, timeout)
+	}
+	if h.options.StreamVerify && tp == DQL {
+		digester := ResultDigester(query, h.options.Compare, h.options.SpillDir)
+		run = func(exec executor.Executor, round uint, sql string, timeout time.Duration) (*Metrics, []executor.Comparable, error) {
+			return RunSQLWithDigest(exec, round, sql, digester, timeout)
+		}
+	}
+	return func(exec executor.Executor, round uint, sql string, timeout time.Duration) (*Metrics, []executor.Comparable, error) {
+		cost, sets, err := run(exec, round, sql, timeout)
+		if err == nil && h.options.Outlier != OutlierIQR {
+			cost.computeStats(h.options.Outlier)
+		}
+		return cost, sets, err
+	}
+}
+
+// throttle makes run wait for a slot of the main pool, so that timed executions of concurrent queries
+// overlap only as Parallelism allows
+func (h *Horoscope) throttle(run runFunc) runFunc {
+	if h.slots == nil {
+		return run
+	}
+	return func(exec executor.Executor, round uint, sql string, timeout time.Duration) (*Metrics, []executor.Comparable, error) {
+		h.slots <- struct{}{}
+		defer func() {
+			<-h.slots
+		}()
+		return run(exec, round, sql, timeout)
+	}
+}
+
+// runPlans runs rounds of all plans ahead of the verification, plans are warmed up before their first rounds, rounds of a plan are run together or interleaved
+// with other plans by Interleave, plans are run concurrently by min(Concurrency, #plans) workers with ParallelFull.
+// A single worker reuses exec and waits for a slot per execution, concurrent workers hold a slot for their lifetime before
+// they take executors of their own, so that they never wait for connections held by each other. Rounds after a failed one are skipped.
+func (h *Horoscope) runPlans(exec executor.Executor, run runFunc, plans []*Bench, round uint, timeout time.Duration) (runs []planRun, err error) {
+	type task struct {
+		plan  int
+		round uint
+	}
+	tasks := make([]task, 0, len(plans))
+	if h.options.Interleave {
+		for r := uint(0); r < round; r++ {
+			for i := range plans {
+				tasks = append(tasks, task{plan: i, round: 1})
+			}
+		}
+	} else {
+		for i := range plans {
+			tasks = append(tasks, task{plan: i, round: round})
+		}
+	}
+
+	workers := 1
+	if h.options.Parallelism == ParallelFull {
+		workers = len(plans)
+		if h.options.Concurrency > 0 && h.options.Concurrency < workers {
+			workers = h.options.Concurrency
+		}
+	}
+
+	runs = make([]planRun, len(plans))
+	warmed := make([]bool, len(plans))
+	for i := range runs {
+		runs[i].cost = &Metrics{Unit: "ms"}
+	}
+	var (
+		mu    sync.Mutex
+		wg    sync.WaitGroup
+		queue = make(chan task)
+		errs  = make(chan error, workers)
+	)
+	work := func(exec executor.Executor, run runFunc) {
+		for t := range queue {
+			mu.Lock()
+			failed, warm := runs[t.plan].err != nil, !warmed[t.plan]
+			warmed[t.plan] = true
+			mu.Unlock()
+			if failed {
+				continue
+			}
+			if warm {
+				h.warmup(exec, run, plans[t.plan], timeout)
+			}
+			cost, sets, err := run(exec, t.round, plans[t.plan].SQL, timeout)
+			mu.Lock()
+			if r := &runs[t.plan]; err != nil {
+				removeSpills(r.sets)
+				r.sets, r.err = nil, err
+			} else {
+				r.cost.Values = append(r.cost.Values, cost.Values...)
+				r.sets = append(r.sets, sets...)
+			}
+			mu.Unlock()
+		}
+	}
+	if workers == 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			work(exec, h.throttle(run))
+		}()
+	}
+	for i := 0; workers > 1 && i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if h.slots != nil {
+				h.slots <- struct{}{}
+				defer func() {
+					<-h.slots
+				}()
+			}
+			exec, closeFunc, err := h.newExecutor(h.exec)
+			if err != nil {
+				errs <- fmt.Errorf("create an executor failed: %w", err)
+				// skip the remaining tasks, all runs are dropped with the error
+				for range queue {
+				}
 				return
 			}
-			defer dCloseFunc()
-			_, results, err = RunSQLWithTime(dExec, benches.Round, benches.DefaultPlan.SQL, benches.Type)
+			defer closeFunc()
+			work(exec, run)
+		}()
+	}
+	for _, t := range tasks {
+		queue <- t
+	}
+	close(queue)
+	wg.Wait()
+	close(errs)
+	if err = <-errs; err != nil {
+		for i := range runs {
+			removeSpills(runs[i].sets)
+		}
+		return nil, err
+	}
+
+	for i := range runs {
+		if runs[i].err == nil {
+			runs[i].cost.computeStats(h.options.Outlier)
+		}
+	}
+	return
+}
+
+// runDifferential runs the default plan on each differential pool, pools are run concurrently unless
+// Parallelism is ParallelNone
+func (h *Horoscope) runDifferential(run runFunc, round uint, sql string) []differentialRun {
+	runs := make([]differentialRun, len(h.differentialExecs))
+	var wg sync.WaitGroup
+	for i, pool := range h.differentialExecs {
+		runOn := func(dRun *differentialRun, pool executor.Pool) {
+			dRun.dsn = pool.Dsn()
+			exec, closeFunc, err := h.newExecutor(pool)
 			if err != nil {
+				dRun.err = err
 				return
 			}
+			defer closeFunc()
+			_, dRun.results, dRun.err = run(exec, round, sql, h.options.PlanTimeout)
+		}
+		if h.options.Parallelism == ParallelNone {
+			if runOn(&runs[i], pool); runs[i].err != nil {
+				return runs[:i+1]
+			}
+			continue
+		}
+		wg.Add(1)
+		go func(dRun *differentialRun, pool executor.Pool) {
+			defer wg.Done()
+			runOn(dRun, pool)
+		}(&runs[i], pool)
+	}
+	wg.Wait()
+	return runs
+}
 
-			for _, result := range results {
-				if !testOracle.Equal(result) {
-					benches.VerifiedFail = true
-					err = fmt.Errorf("results mismatch in different DSN: %s <=> %s", exec.Dsn(), dExec.Dsn())
-					return
-				}
+func removeSpills(results []executor.Comparable) {
+	for _, result := range results {
+		if digest, ok := result.(executor.ResultDigest); ok {
+			if err := digest.Remove(); err != nil {
+				log.WithFields(log.Fields{
+					"file": digest.SpillFile,
+					"err":  err.Error(),
+				}).Warn("fail to remove the spilled result")
 			}
 		}
 	}
-	return
 }
 
-func RunSQLWithTime(exec executor.Executor, round uint, query string, tp QueryType) (*Metrics, []executor.Comparable, error) {
+// RunSQLWithTime executes the query `round` times, each execution is interrupted after timeout if it is not zero.
+// A *executor.TimeoutError is returned as is, other errors are wrapped in ServerError.
+func RunSQLWithTime(exec executor.Executor, round uint, query string, tp QueryType, timeout time.Duration) (*Metrics, []executor.Comparable, error) {
+	return runWithTime(round, query, timeout, func(ctx context.Context) (executor.Comparable, error) {
+		switch tp {
+		case DQL:
+			return exec.QueryContext(ctx, query)
+		case DML:
+			return exec.ExecContext(ctx, query)
+		default:
+			panic("Next type should be checked in `collectPlans`")
+		}
+	})
+}
+
+// RunSQLWithDigest is RunSQLWithTime of DQL which only keeps digests of results, results are spilled if it is
+// required by the digester
+func RunSQLWithDigest(exec executor.Executor, round uint, query string, digester executor.Digester, timeout time.Duration) (*Metrics, []executor.Comparable, error) {
+	return runWithTime(round, query, timeout, func(ctx context.Context) (executor.Comparable, error) {
+		stream, err := exec.QueryStreamContext(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		return digester.DigestStream(&stream)
+	})
+}
+
+func runWithTime(round uint, query string, timeout time.Duration, run func(ctx context.Context) (executor.Comparable, error)) (*Metrics, []executor.Comparable, error) {
 	var (
 		costs = Metrics(benchstat.Metrics{
 			Unit: "ms",
@@ -195,33 +986,169 @@ func RunSQLWithTime(exec executor.Executor, round uint, query string, tp QueryTy
 	}).Debug("query with time")
 
 	for i := 0; i < int(round); i++ {
+		ctx, cancel := context.Background(), func() {}
+		if timeout != 0 {
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+		}
 		start := time.Now()
 		var rows executor.Comparable
-		switch tp {
-		case DQL:
-			rows, err = exec.Query(query)
-		case DML:
-			rows, err = exec.Exec(query)
-		default:
-			panic("Next type should be checked in `collectPlans`")
-		}
+		rows, err = run(ctx)
+		cancel()
 		if err != nil {
+			removeSpills(list)
+			if executor.IsTimeout(err) {
+				return nil, nil, err
+			}
 			return nil, nil, ServerError{err}
 		}
-		costs.Values = append(costs.Values, float64(time.Since(start).Microseconds()/1000))
+		costs.Values = append(costs.Values, milliseconds(time.Since(start)))
 		list = append(list, rows)
 	}
 
-	costs.computeStats()
+	costs.computeStats(OutlierIQR)
 	return &costs, list, nil
 }
 
-func (h *Horoscope) CollectCardinalityEstimationError(query string) (baseTable []*executor.CardinalityInfo, join []*executor.CardinalityInfo, err error) {
+// addRounds runs more rounds of the default plan and plans undecided by the judge until no plan is
+// undecided or MaxRounds is reached, plans decided or failed in a step get no more rounds.
+// Results of the extra rounds are not verified.
+func (h *Horoscope) addRounds(exec executor.Executor, run runFunc, benches *Benches, timeout time.Duration) {
+	step := benches.Round
+	for rounds := benches.Round; rounds < h.options.MaxRounds; rounds += step {
+		if rounds+step > h.options.MaxRounds {
+			step = h.options.MaxRounds - rounds
+		}
+		var pending []*Bench
+		for _, plan := range benches.Plans {
+			if plan.Plan != benches.DefaultPlan.Plan && plan.Cost != nil && uint(len(plan.Cost.Values)) == rounds &&
+				benches.Verdict(plan).Undecided {
+				pending = append(pending, plan)
+			}
+		}
+		if len(pending) == 0 {
+			return
+		}
+		log.WithFields(log.Fields{
+			"query id": benches.QueryID,
+			"rounds":   rounds + step,
+			"plans":    len(pending),
+		}).Debug("add rounds to undecided plans")
+		if !h.extend(exec, run, &benches.DefaultPlan, step, h.options.PlanTimeout) {
+			return
+		}
+		for _, plan := range pending {
+			h.extend(exec, run, plan, step, timeout)
+		}
+	}
+}
+
+// extend adds costs of more rounds to the plan after warming it up again, other plans ran in between,
+// it reports false if the rounds fail
+func (h *Horoscope) extend(exec executor.Executor, run runFunc, plan *Bench, round uint, timeout time.Duration) bool {
+	h.warmup(exec, run, plan, timeout)
+	cost, sets, err := run(exec, round, plan.SQL, timeout)
+	removeSpills(sets)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"query": plan.SQL,
+			"err":   err.Error(),
+		}).Warnf("fail to add rounds to plan%d", plan.Plan)
+		return false
+	}
+	plan.Cost.Values = append(plan.Cost.Values, cost.Values...)
+	plan.Cost.computeStats(h.options.Outlier)
+	return true
+}
+
+// warmup runs the plan Warmup times and drops the costs and the results, failures are left to the timed rounds
+func (h *Horoscope) warmup(exec executor.Executor, run runFunc, plan *Bench, timeout time.Duration) {
+	if h.options.Warmup == 0 {
+		return
+	}
+	_, sets, err := run(exec, h.options.Warmup, plan.SQL, timeout)
+	removeSpills(sets)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"query": plan.SQL,
+			"err":   err.Error(),
+		}).Debugf("fail to warm up plan%d", plan.Plan)
+	}
+}
+
+// collectServerMetrics runs EXPLAIN ANALYZE of the plan `round` times if ServerMetrics is enabled,
+// failures are only logged since the plan has been measured
+func (h *Horoscope) collectServerMetrics(exec executor.Executor, plan *Bench, round uint, timeout time.Duration) {
+	if !h.options.ServerMetrics {
+		return
+	}
+	if h.slots != nil {
+		h.slots <- struct{}{}
+		defer func() {
+			<-h.slots
+		}()
+	}
+	stats := make([]executor.ServerStats, 0, round)
+	for i := 0; i < int(round); i++ {
+		ctx, cancel := context.Background(), func() {}
+		if timeout != 0 {
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+		}
+		rows, _, err := exec.ExplainAnalyzeContext(ctx, plan.SQL)
+		cancel()
+		var info *executor.ExplainAnalyzeInfo
+		if err == nil {
+			info, err = executor.NewExplainAnalyzeInfo(rows)
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"query": plan.SQL,
+				"err":   err.Error(),
+			}).Warnf("fail to collect server metrics of plan%d", plan.Plan)
+			return
+		}
+		stats = append(stats, info.ServerStats())
+	}
+	plan.Server = newServerMetrics(stats, h.options.Outlier)
+}
+
+// planTimeout returns the timeout of alternative plans,
+// censored means a plan interrupted by it is known to be slower than PlanTimeoutFactor × the default plan
+func (h *Horoscope) planTimeout(defaultCost *Metrics) (timeout time.Duration, censored bool) {
+	timeout = h.options.PlanTimeout
+	if h.options.PlanTimeoutFactor == 0 {
+		return
+	}
+	bound := time.Duration(h.options.PlanTimeoutFactor * defaultCost.Mean * float64(time.Millisecond))
+	relative := bound
+	if relative < minRelativeTimeout {
+		relative = minRelativeTimeout
+	}
+	if timeout == 0 || relative < timeout {
+		timeout = relative
+	}
+	return timeout, timeout >= bound
+}
+
+// Analyze runs EXPLAIN ANALYZE on the query and parses the operator tree
+func (h *Horoscope) Analyze(query string) (*executor.ExplainAnalyzeInfo, error) {
 	rows, _, err := h.exec.Executor().ExplainAnalyze(query)
 	if err != nil {
-		return nil, nil, fmt.Errorf("explain analyze error: %v", err)
+		return nil, fmt.Errorf("explain analyze error: %v", err)
 	}
-	cis := executor.CollectEstAndActRows(executor.NewExplainAnalyzeInfo(rows))
+	return executor.NewExplainAnalyzeInfo(rows)
+}
+
+func (h *Horoscope) CollectCardinalityEstimationError(query string) (baseTable []*executor.CardinalityInfo, join []*executor.CardinalityInfo, err error) {
+	analysis, err := h.Analyze(query)
+	if err != nil {
+		return nil, nil, err
+	}
+	baseTable, join = CardinalityEstimationError(analysis)
+	return
+}
+
+func CardinalityEstimationError(analysis *executor.ExplainAnalyzeInfo) (baseTable []*executor.CardinalityInfo, join []*executor.CardinalityInfo) {
+	cis := executor.CollectEstAndActRows(analysis)
 	for _, ci := range cis {
 		if ci.Op == "Selection" {
 			baseTable = append(baseTable, ci)
@@ -243,11 +1170,12 @@ func (h *Horoscope) collectPlans(queryID string, query ast.StmtNode, maxPlans ui
 		return
 	}
 
-	explanation, _, err := h.exec.Executor().Explain(sql)
+	rows, _, err := h.exec.Executor().Explain(sql)
 	if err != nil {
 		return
 	}
-	log.Infof("query explain start %s:\n%s\nquery explain end\n", queryID, explanation.String())
+	explanation, digest := parsePlan(queryID, 0, rows)
+	log.Infof("query explain start %s:\n%s\nquery explain end\n", queryID, rows.String())
 
 	benches = &Benches{
 		QueryID: queryID,
@@ -256,6 +1184,7 @@ func (h *Horoscope) collectPlans(queryID string, query ast.StmtNode, maxPlans ui
 			SQL:         sql,
 			Hints:       hints,
 			Explanation: explanation,
+			Digest:      digest,
 		},
 		Query: query,
 		Plans: make([]*Bench, 0),
@@ -267,6 +1196,7 @@ func (h *Horoscope) collectPlans(queryID string, query ast.StmtNode, maxPlans ui
 		return
 	}
 
+	digests := make(map[string]uint64)
 	var id uint64 = 1
 	for ; id <= maxPlans; id++ {
 		var plan string
@@ -277,7 +1207,7 @@ func (h *Horoscope) collectPlans(queryID string, query ast.StmtNode, maxPlans ui
 			return
 		}
 
-		explanation, warnings, err = h.exec.Executor().Explain(plan)
+		rows, warnings, err = h.exec.Executor().Explain(plan)
 		if err != nil {
 			return
 		}
@@ -288,12 +1218,23 @@ func (h *Horoscope) collectPlans(queryID string, query ast.StmtNode, maxPlans ui
 			}
 		}
 
+		explanation, digest = parsePlan(queryID, id, rows)
+		benches.RawPlanCount++
+		if representative, ok := digests[digest]; ok {
+			log.WithFields(log.Fields{
+				"query id":       queryID,
+				"representative": representative,
+			}).Debugf("plan%d is a duplicate", id)
+			continue
+		}
+		digests[digest] = id
+
 		hints, err = h.exec.Executor().GetHints(plan)
 		if err != nil {
 			return
 		}
 
-		if benches.DefaultPlan.Explanation.Equal(explanation) {
+		if benches.DefaultPlan.Digest == digest {
 			benches.DefaultPlan.Plan = id
 		}
 
@@ -301,6 +1242,7 @@ func (h *Horoscope) collectPlans(queryID string, query ast.StmtNode, maxPlans ui
 			&Bench{
 				Hints:       hints,
 				Explanation: explanation,
+				Digest:      digest,
 				Plan:        id,
 				SQL:         plan,
 			})
@@ -308,13 +1250,39 @@ func (h *Horoscope) collectPlans(queryID string, query ast.StmtNode, maxPlans ui
 	return
 }
 
-func newExecutorFromPool(pool executor.Pool, explicitTxn bool) (exec executor.Executor, closeFunc func(), err error) {
-	if explicitTxn {
+// parsePlan builds the plan tree of an explanation,
+// a plan whose layout cannot be parsed is kept without a tree and digested by its raw rows
+func parsePlan(queryID string, plan uint64, rows executor.Rows) (explanation *executor.PlanTree, digest string) {
+	explanation, err := executor.NewPlanTree(rows)
+	if err == nil {
+		return explanation, explanation.Digest()
+	}
+	log.WithFields(log.Fields{
+		"query id": queryID,
+		"plan":     plan,
+		"error":    err.Error(),
+	}).Warn("cannot parse the explanation")
+	hash := sha256.Sum256([]byte(rows.String()))
+	return nil, hex.EncodeToString(hash[:])
+}
+
+// newExecutor returns an executor of the pool with a func to release it
+func (h *Horoscope) newExecutor(pool executor.Pool) (exec executor.Executor, closeFunc func(), err error) {
+	if h.explicitTxn {
 		exec, err := pool.Transaction()
 		return exec, func() {
 			exec.Rollback()
 		}, err
 	}
+	if h.options.ConnAffinity {
+		conn, err := pool.Conn(context.Background())
+		if err != nil {
+			return nil, nil, err
+		}
+		return conn, func() {
+			conn.Close()
+		}, nil
+	}
 	return pool.Executor(), func() {}, nil
 }
 
@@ -362,33 +1330,7 @@ func AnalyzeQuery(query ast.StmtNode, sql string) (tp QueryType, hints *[]*ast.T
 	return
 }
 
+// IsSubOptimal judges the plan by DefaultJudge
 func IsSubOptimal(defPlan *Bench, plan *Bench) bool {
-	const alpha, thresholdPct = 0.05, 0.9
-	// Some plans failed because of server errors, ignore this case
-	if plan.Cost == nil {
-		return false
-	}
-	if plan.Cost.Mean >= thresholdPct*defPlan.Cost.Mean {
-		return false
-	}
-	defaultPlanCost, currentPlanCost := defPlan.Cost, plan.Cost
-	pVal, testErr := benchstat.TTest(&benchstat.Metrics{
-		Unit:    defaultPlanCost.Unit,
-		Values:  defaultPlanCost.Values,
-		RValues: defaultPlanCost.RValues,
-		Min:     defaultPlanCost.Min,
-		Mean:    defaultPlanCost.Mean,
-		Max:     defaultPlanCost.Max,
-	}, &benchstat.Metrics{
-		Unit:    currentPlanCost.Unit,
-		Values:  currentPlanCost.Values,
-		RValues: currentPlanCost.RValues,
-		Min:     currentPlanCost.Min,
-		Mean:    currentPlanCost.Mean,
-		Max:     currentPlanCost.Max,
-	})
-	if testErr != nil || pVal < alpha {
-		return true
-	}
-	return false
+	return judgePlan(DefaultJudge, defPlan, plan).Better
 }
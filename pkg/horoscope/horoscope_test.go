@@ -14,13 +14,29 @@
 package horoscope
 
 import (
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/go-sql-driver/mysql"
 	"github.com/pingcap/parser"
 	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/tidb/errno"
 	_ "github.com/pingcap/tidb/types/parser_driver"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/chaos-mesh/horoscope/pkg/executor"
+	"github.com/chaos-mesh/horoscope/pkg/executor/fake"
 )
 
 func TestHoroscope_Plan(t *testing.T) {
@@ -32,3 +48,616 @@ func TestHoroscope_Plan(t *testing.T) {
 	assert.True(t, ok)
 	fmt.Printf("%#v", selectStmt.TableHints[0])
 }
+
+type queries []string
+
+func (q *queries) Next() (string, ast.StmtNode) {
+	if len(*q) == 0 {
+		return "", nil
+	}
+	sql := (*q)[0]
+	*q = (*q)[1:]
+	stmt, err := parser.New().ParseOneStmt(sql, "", "")
+	if err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf("q%d", len(*q)), stmt
+}
+
+var explainColumns = []string{"id", "estRows", "task", "access object", "operator info"}
+
+var (
+	tableScan = fake.Rows(explainColumns,
+		[]string{"TableReader_5", "3.00", "root", "", "data:TableFullScan_4"},
+		[]string{"└─TableFullScan_4", "3.00", "cop[tikv]", "table:t", "keep order:false"},
+	)
+	indexScan = fake.Rows(explainColumns,
+		[]string{"IndexReader_6", "3.00", "root", "", "index:IndexFullScan_5"},
+		[]string{"└─IndexFullScan_5", "3.00", "cop[tikv]", "table:t, index:a(a)", "keep order:false"},
+	)
+	result = fake.Rows([]string{"a"}, []string{"1"}, []string{"2"})
+)
+
+// newFakePool serves `SELECT a FROM t` in 20ms, plan 1 and plan 2 scan the table as the default plan does,
+// plan 3 scans the index and plans are out of range since plan 4
+func newFakePool(dsn string) *fake.Pool {
+	pool := fake.NewPool(dsn)
+	pool.On(`^explain format = 'hint'`).Return(fake.Rows([]string{"hint"}, []string{"use_index(@`sel_1` `test`.`t` )"}))
+	pool.On(`^EXPLAIN SELECT`).Return(tableScan)
+	pool.On(`^EXPLAIN .*NTH_PLAN\(3\)`).Return(indexScan)
+	pool.On(`^EXPLAIN .*NTH_PLAN\(([4-9]|\d\d+)\)`).Return(tableScan).Warn(fake.PlanOutOfRange)
+	pool.On(`^SELECT`).Return(result).Delay(20 * time.Millisecond)
+	return pool
+}
+
+func TestHoroscope_Next(t *testing.T) {
+	for _, testCase := range []struct {
+		name         string
+		setup        func(pool, other *fake.Pool)
+		err          string
+		verifiedFail bool
+		source       string
+		better       []uint64
+	}{
+		{
+			name: "no better plan",
+		},
+		{
+			name: "better plan",
+			setup: func(pool, other *fake.Pool) {
+				pool.On(`^SELECT .*NTH_PLAN\(3\)`).Return(result).Delay(time.Millisecond)
+			},
+			better: []uint64{3},
+		},
+		{
+			name: "plan mismatch",
+			setup: func(pool, other *fake.Pool) {
+				pool.On(`^SELECT .*NTH_PLAN\(3\)`).Return(fake.Rows([]string{"a"}, []string{"1"}))
+			},
+			err:          "results mismatch in plan(3)",
+			verifiedFail: true,
+			source:       "plan(3)",
+		},
+		{
+			name: "differential mismatch",
+			setup: func(pool, other *fake.Pool) {
+				other.On(`^SELECT`).Return(fake.Rows([]string{"a"}, []string{"2"}, []string{"3"}))
+			},
+			err:          "results mismatch in different DSN",
+			verifiedFail: true,
+			source:       "other",
+		},
+		{
+			name: "server error",
+			setup: func(pool, other *fake.Pool) {
+				pool.On(`^SELECT .*NTH_PLAN\(3\)`).Fail(errors.New("unknown error"))
+			},
+			err: "unknown error",
+		},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			pool, other := newFakePool("main"), fake.NewPool("other")
+			other.On(`^SELECT`).Return(result)
+			if testCase.setup != nil {
+				testCase.setup(pool, other)
+			}
+			horo := NewHoroscope(pool, []executor.Pool{other}, &queries{"SELECT a FROM t"}, false, Options{})
+
+			benches, err := horo.Next(3, 10, true, false)
+			if testCase.err != "" {
+				require.NotNil(t, err)
+				assert.Contains(t, err.Error(), testCase.err)
+			} else {
+				require.Nil(t, err)
+			}
+			if testCase.verifiedFail {
+				require.NotNil(t, benches)
+				assert.True(t, benches.VerifiedFail)
+				require.NotNil(t, benches.Mismatch)
+				assert.Equal(t, testCase.source, benches.Mismatch.Source)
+				// zero DiffLimit falls back to DefaultDiffLimit
+				assert.False(t, benches.Mismatch.Diff.Truncated)
+			}
+			if err != nil {
+				return
+			}
+
+			assert.Equal(t, 3, benches.RawPlanCount)
+			require.Len(t, benches.Plans, 2)
+			assert.Equal(t, []uint64{1, 3}, []uint64{benches.Plans[0].Plan, benches.Plans[1].Plan})
+			assert.Equal(t, uint64(1), benches.DefaultPlan.Plan)
+			assert.Len(t, benches.DefaultPlan.Cost.Values, 3)
+
+			var better []uint64
+			for _, plan := range benches.Plans {
+				if IsSubOptimal(&benches.DefaultPlan, plan) {
+					better = append(better, plan.Plan)
+				}
+			}
+			assert.Equal(t, testCase.better, better)
+
+			collection := BenchCollection{benches}
+			table := collection.Table()
+			require.Len(t, table.Rows, 1)
+			assert.Equal(t, 2, table.Rows[0].PlanSpaceCount)
+			assert.Len(t, table.Rows[0].OptimalPlan, len(testCase.better))
+
+			next, err := horo.Next(3, 10, true, false)
+			assert.Nil(t, err)
+			assert.Nil(t, next)
+		})
+	}
+}
+
+func TestHoroscope_NextWithUnparsedPlan(t *testing.T) {
+	pool := newFakePool("main")
+	pool.On(`^EXPLAIN .*NTH_PLAN\(3\)`).Return(fake.Rows([]string{"EXPLAIN"}, []string{"Projection a"}))
+	horo := NewHoroscope(pool, nil, &queries{"SELECT a FROM t"}, false, Options{})
+
+	benches, err := horo.Next(1, 10, true, false)
+	require.Nil(t, err)
+	assert.Equal(t, 3, benches.RawPlanCount)
+	require.Len(t, benches.Plans, 2)
+	assert.NotNil(t, benches.Plans[0].Explanation)
+	assert.Nil(t, benches.Plans[1].Explanation)
+	assert.NotEmpty(t, benches.Plans[1].Digest)
+	assert.NotEqual(t, benches.Plans[0].Digest, benches.Plans[1].Digest)
+}
+
+func TestHoroscope_NextWithPlanTimeout(t *testing.T) {
+	pool := newFakePool("main")
+	pool.On(`^SELECT .*NTH_PLAN\(3\)`).Return(result).Delay(time.Second)
+	horo := NewHoroscope(pool, nil, &queries{"SELECT a FROM t"}, false, Options{PlanTimeoutFactor: 2})
+
+	benches, err := horo.Next(1, 10, true, false)
+	require.Nil(t, err)
+	require.Len(t, benches.Plans, 2)
+	assert.True(t, benches.Plans[1].Censored)
+	assert.False(t, IsSubOptimal(&benches.DefaultPlan, benches.Plans[1]))
+}
+
+func TestHoroscope_NextWithDefaultPlanTimeout(t *testing.T) {
+	pool := newFakePool("main")
+	pool.On(`^SELECT`).Return(result).Delay(time.Second)
+	horo := NewHoroscope(pool, nil, &queries{"SELECT a FROM t"}, false, Options{PlanTimeout: 50 * time.Millisecond})
+
+	benches, err := horo.Next(1, 10, true, false)
+	require.NotNil(t, err)
+	assert.True(t, executor.IsTimeout(err))
+	require.NotNil(t, benches)
+	assert.True(t, benches.DefaultPlan.TimedOut)
+
+	collection := BenchCollection{benches}
+	table := collection.Table()
+	require.Len(t, table.Rows, 1)
+	assert.Equal(t, "q0", table.Rows[0].QueryId)
+	assert.True(t, table.Rows[0].TimedOut)
+	assert.Contains(t, table.String(), "timed out")
+}
+
+func TestHoroscope_NextWithPreparedFailure(t *testing.T) {
+	pool := newFakePool("main")
+	pool.On(`\?`).Fail(errors.New("unknown error"))
+	horo := NewHoroscope(pool, nil, &queries{"SELECT a FROM t WHERE a > 1"}, false, Options{PreparedParamSets: 2})
+
+	benches, err := horo.Next(1, 10, true, false)
+	require.Nil(t, err)
+	assert.Nil(t, benches.Prepared)
+	assert.False(t, benches.VerifiedFail)
+	require.Len(t, benches.Plans, 2)
+	for _, plan := range benches.Plans {
+		assert.NotNil(t, plan.Cost)
+	}
+}
+
+func TestHoroscope_PlanTimeout(t *testing.T) {
+	for _, testCase := range []struct {
+		name        string
+		planTimeout time.Duration
+		factor      float64
+		mean        float64
+		timeout     time.Duration
+		censored    bool
+	}{
+		{"no factor", time.Second, 0, 20, time.Second, false},
+		{"relative", 0, 2, 20, 40 * time.Millisecond, true},
+		{"relative below plan timeout", time.Second, 2, 20, 40 * time.Millisecond, true},
+		{"plan timeout equal to relative", 40 * time.Millisecond, 2, 20, 40 * time.Millisecond, true},
+		{"plan timeout below relative", 30 * time.Millisecond, 2, 20, 30 * time.Millisecond, false},
+		{"plan timeout below the minimum", 5 * time.Millisecond, 2, 2, 5 * time.Millisecond, true},
+		{"minimum", 0, 2, 2, minRelativeTimeout, true},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			horo := NewHoroscope(newFakePool("main"), nil, &queries{}, false, Options{
+				PlanTimeout:       testCase.planTimeout,
+				PlanTimeoutFactor: testCase.factor,
+			})
+			timeout, censored := horo.planTimeout(&Metrics{Mean: testCase.mean})
+			assert.Equal(t, testCase.timeout, timeout)
+			assert.Equal(t, testCase.censored, censored)
+		})
+	}
+}
+
+func TestHoroscope_NextWithRetry(t *testing.T) {
+	for _, testCase := range []struct {
+		name    string
+		failure error
+		times   int
+		retries []executor.ErrorClass
+		err     bool
+	}{
+		{"crash", mysql.ErrInvalidConn, 1, []executor.ErrorClass{executor.ErrorCrash}, false},
+		{"network", &mysql.MySQLError{Number: errno.ErrTiKVServerTimeout, Message: "TiKV server timeout"}, 2,
+			[]executor.ErrorClass{executor.ErrorNetwork, executor.ErrorNetwork}, false},
+		{"exhausted", mysql.ErrInvalidConn, 3, []executor.ErrorClass{executor.ErrorCrash, executor.ErrorCrash}, true},
+		{"not transient", errors.New("unknown error"), 1, nil, true},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			pool := newFakePool("main")
+			pool.On(`^SELECT .*NTH_PLAN\(3\)`).Fail(testCase.failure).Times(testCase.times)
+			horo := NewHoroscope(pool, nil, &queries{"SELECT a FROM t"}, false, Options{
+				Retry: executor.RetryPolicy{MaxRetries: 2, Backoff: time.Millisecond},
+			})
+
+			benches, err := horo.Next(1, 10, true, false)
+			if testCase.err {
+				require.NotNil(t, err)
+			} else {
+				require.Nil(t, err)
+				assert.Equal(t, "SELECT a FROM t", benches.DefaultPlan.SQL)
+				assert.Len(t, benches.Plans, 2)
+			}
+			var classes []executor.ErrorClass
+			if benches != nil {
+				for _, retry := range benches.Retries {
+					classes = append(classes, retry.Class)
+				}
+			}
+			assert.Equal(t, testCase.retries, classes)
+		})
+	}
+}
+
+func TestHoroscope_NextWithCrash(t *testing.T) {
+	uptime := func(seconds string) executor.Rows {
+		return fake.Rows([]string{"Variable_name", "Value"}, []string{"Uptime", seconds})
+	}
+	hostname := func(host string) executor.Rows {
+		return fake.Rows([]string{"@@hostname"}, []string{host})
+	}
+	for _, testCase := range []struct {
+		name    string
+		crash   string
+		restart bool
+		host    string
+		err     bool
+		crashes []uint64
+	}{
+		{"plan crashed", `^SELECT .*NTH_PLAN\(3\)`, true, "tidb-0", false, []uint64{3}},
+		{"default plan crashed", `^SELECT a FROM t$`, true, "tidb-0", true, []uint64{1}},
+		{"not restarted", `^SELECT .*NTH_PLAN\(3\)`, false, "tidb-0", true, nil},
+		{"another server", `^SELECT .*NTH_PLAN\(3\)`, true, "tidb-1", true, nil},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			pool := newFakePool("main")
+			if testCase.restart {
+				pool.On(`Uptime`).Return(uptime("1"))
+			} else {
+				pool.On(`Uptime`).Return(uptime("1000"))
+			}
+			pool.On(`Uptime`).Return(uptime("1000")).Times(1)
+			pool.On(`@@hostname`).Return(hostname(testCase.host))
+			pool.On(`@@hostname`).Return(hostname("tidb-0")).Times(1)
+			pool.On(testCase.crash).Fail(mysql.ErrInvalidConn).Times(1)
+			horo := NewHoroscope(pool, nil, &queries{"SELECT a FROM t"}, false, Options{CrashRecovery: time.Second})
+
+			benches, err := horo.Next(1, 10, true, false)
+			assert.Equal(t, testCase.err, err != nil)
+			var crashes []uint64
+			if benches != nil {
+				for _, crash := range benches.Crashes {
+					assert.Equal(t, mysql.ErrInvalidConn.Error(), crash.Err)
+					crashes = append(crashes, crash.Plan)
+				}
+			}
+			assert.Equal(t, testCase.crashes, crashes)
+			if err == nil {
+				require.Len(t, benches.Plans, 2)
+				assert.True(t, benches.Plans[1].Crashed)
+				assert.Equal(t, "SELECT /*+ NTH_PLAN(3)*/ a FROM t", benches.Crashes[0].SQL)
+				collection := BenchCollection{benches}
+				assert.Equal(t, []string{"#3"}, collection.Table().Rows[0].CrashedPlan)
+			}
+		})
+	}
+}
+
+func TestHoroscope_NextWithServerMetrics(t *testing.T) {
+	pool := newFakePool("main")
+	pool.On(`^EXPLAIN ANALYZE`).Return(fake.Rows([]string{"id", "estRows", "actRows", "task", "access object", "execution info", "operator info", "memory", "disk"},
+		[]string{"TableReader_5", "3.00", "3", "root", "", "time:1.5ms, loops:2, cop_task: {num: 1, max: 1ms, proc_keys: 3, tot_proc: 250µs}", "data:TableFullScan_4", "256 Bytes", "N/A"},
+		[]string{"└─TableFullScan_4", "3.00", "3", "cop[tikv]", "table:t", "tikv_task:{time:0s, loops:1}", "keep order:false", "N/A", "N/A"},
+	))
+	horo := NewHoroscope(pool, nil, &queries{"SELECT a FROM t"}, false, Options{ServerMetrics: true})
+
+	benches, err := horo.Next(2, 10, true, false)
+	require.Nil(t, err)
+	for _, plan := range append([]*Bench{&benches.DefaultPlan}, benches.Plans...) {
+		require.NotNil(t, plan.Server)
+		assert.Equal(t, []float64{1.5, 1.5}, plan.Server.Latency.Values)
+		assert.Equal(t, 3.0, plan.Server.ProcessedKeys.Mean)
+		assert.Equal(t, 256.0, plan.Server.Memory.Mean)
+		assert.Equal(t, 0.25, plan.Server.CPUTime.Mean)
+	}
+	assert.Equal(t, "1.500ms, keys: 3, mem: 256 bytes, cpu: 0.250ms", benches.DefaultPlan.Server.String())
+}
+
+func TestHoroscope_NextWithConnAffinity(t *testing.T) {
+	for _, affinity := range []bool{false, true} {
+		pool, other := newFakePool("main"), fake.NewPool("other")
+		other.On(`^SELECT`).Return(result)
+		horo := NewHoroscope(pool, []executor.Pool{other}, &queries{"SELECT a FROM t"}, false, Options{ConnAffinity: affinity})
+
+		_, err := horo.Next(3, 10, true, false)
+		require.Nil(t, err)
+		conns := 0
+		if affinity {
+			conns = 1
+		}
+		assert.Equal(t, conns, pool.Conns())
+		assert.Equal(t, conns, other.Conns())
+	}
+}
+
+func TestHoroscope_NextWithCapture(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capture")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/plan_replayer/dump/replayer_abc.zip", r.URL.Path)
+		w.Write([]byte("bundle"))
+	}))
+	defer server.Close()
+
+	for _, statusAddr := range []string{"", strings.TrimPrefix(server.URL, "http://")} {
+		pool := newFakePool("main")
+		pool.On(`^SELECT .*NTH_PLAN\(3\)`).Return(result).Delay(time.Millisecond)
+		pool.On(`^TRACE FORMAT = 'row'`).Return(fake.Rows([]string{"operation", "startTS", "duration"}, []string{"planner.Optimize", "10:00:00.000000", "1ms"}))
+		pool.On(`^PLAN REPLAYER DUMP EXPLAIN SELECT a FROM t$`).Return(fake.Rows([]string{"File_token"}, []string{"replayer_abc.zip"}))
+		horo := NewHoroscope(pool, nil, &queries{"SELECT a FROM t"}, false, Options{CaptureDir: dir, StatusAddr: statusAddr})
+
+		benches, err := horo.Next(3, 10, true, false)
+		require.Nil(t, err)
+		require.NotNil(t, benches.Capture)
+		assert.Equal(t, filepath.Join(dir, benches.QueryID), benches.Capture.Dir)
+		bundle, content := "plan_replayer.token", "replayer_abc.zip"
+		if statusAddr != "" {
+			bundle, content = "plan_replayer.zip", "bundle"
+		}
+		assert.Equal(t, []string{"query.sql", "trace-plan1.txt", "trace-plan3.txt", bundle}, benches.Capture.Files)
+		data, err := ioutil.ReadFile(filepath.Join(benches.Capture.Dir, bundle))
+		require.Nil(t, err)
+		assert.Equal(t, content, string(data))
+		data, err = ioutil.ReadFile(filepath.Join(benches.Capture.Dir, "trace-plan3.txt"))
+		require.Nil(t, err)
+		assert.Contains(t, string(data), "planner.Optimize")
+	}
+}
+
+func TestHoroscope_NextWithParallelism(t *testing.T) {
+	for _, options := range []Options{
+		{Parallelism: ParallelVerify},
+		{Parallelism: ParallelVerify, Interleave: true},
+		{Parallelism: ParallelFull, Concurrency: 2},
+		{Parallelism: ParallelFull, Interleave: true},
+	} {
+		// costs under contention are too noisy for statistical judges and tight thresholds
+		options.Judge = NewThresholdJudge(0.5)
+		pool, other := newFakePool("main"), fake.NewPool("other")
+		pool.On(`^SELECT .*NTH_PLAN\(3\)`).Return(result).Delay(time.Millisecond)
+		other.On(`^SELECT`).Return(result)
+		horo := NewHoroscope(pool, []executor.Pool{other}, &queries{"SELECT a FROM t", "SELECT a FROM t WHERE a > 0"}, false, options)
+
+		var (
+			mu         sync.Mutex
+			wg         sync.WaitGroup
+			collection BenchCollection
+		)
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					benches, err := horo.Next(3, 10, true, false)
+					assert.Nil(t, err)
+					if benches == nil {
+						return
+					}
+					mu.Lock()
+					collection = append(collection, benches)
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+
+		require.Len(t, collection, 2)
+		for _, benches := range collection {
+			require.Len(t, benches.Plans, 2)
+			assert.False(t, benches.VerifiedFail)
+			for _, plan := range benches.Plans {
+				assert.Len(t, plan.Cost.Values, 3)
+			}
+			assert.False(t, benches.Verdict(benches.Plans[0]).Better)
+			assert.True(t, benches.Verdict(benches.Plans[1]).Better)
+		}
+		assert.Equal(t, 0, pool.Conns())
+	}
+}
+
+func TestHoroscope_NextWithBoundedPool(t *testing.T) {
+	// three query workers hold a connection each by ConnAffinity, the fake pool takes no `KILL`, so two connections are left
+	pool := newFakePool("main").WithMaxConns(5)
+	var sqls queries
+	for i := 0; i < 20; i++ {
+		sqls = append(sqls, fmt.Sprintf("SELECT a FROM t WHERE a > %d", i))
+	}
+	horo := NewHoroscope(pool, nil, &sqls, false, Options{Parallelism: ParallelFull, Concurrency: 2, ConnAffinity: true})
+
+	var (
+		mu         sync.Mutex
+		wg         sync.WaitGroup
+		collection BenchCollection
+		done       = make(chan struct{})
+	)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				benches, err := horo.Next(2, 10, false, false)
+				assert.Nil(t, err)
+				if benches == nil {
+					return
+				}
+				mu.Lock()
+				collection = append(collection, benches)
+				mu.Unlock()
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("deadlock on the bounded pool")
+	}
+	require.Len(t, collection, 20)
+	for _, benches := range collection {
+		require.Len(t, benches.Plans, 2)
+		for _, plan := range benches.Plans {
+			assert.Len(t, plan.Cost.Values, 2)
+		}
+	}
+}
+
+func TestHoroscope_NextWithInterleave(t *testing.T) {
+	for _, interleave := range []bool{false, true} {
+		pool := newFakePool("main")
+		horo := NewHoroscope(pool, nil, &queries{"SELECT a FROM t"}, false, Options{Interleave: interleave})
+
+		_, err := horo.Next(2, 10, false, false)
+		require.Nil(t, err)
+		var plans []string
+		for _, statement := range pool.Statements() {
+			if match := regexp.MustCompile(`^SELECT .*NTH_PLAN\((\d+)\)`).FindStringSubmatch(statement); match != nil {
+				plans = append(plans, match[1])
+			}
+		}
+		expected := []string{"1", "1", "3", "3"}
+		if interleave {
+			expected = []string{"1", "3", "1", "3"}
+		}
+		assert.Equal(t, expected, plans)
+	}
+}
+
+func TestHoroscope_NextWithMaxRounds(t *testing.T) {
+	for _, testCase := range []struct {
+		name          string
+		delays        []time.Duration
+		defaultRounds int
+		rounds        int
+		better        bool
+		significant   bool
+	}{
+		{
+			name:          "decided better plan",
+			delays:        []time.Duration{time.Millisecond},
+			defaultRounds: 3,
+			rounds:        3,
+			better:        true,
+			significant:   true,
+		},
+		{
+			name:          "decided slower plan",
+			delays:        []time.Duration{40 * time.Millisecond},
+			defaultRounds: 3,
+			rounds:        3,
+			significant:   true,
+		},
+		{
+			name: "undecided plan",
+			delays: []time.Duration{
+				5 * time.Millisecond, 25 * time.Millisecond, 5 * time.Millisecond,
+				25 * time.Millisecond, 5 * time.Millisecond, 25 * time.Millisecond,
+				5 * time.Millisecond, 25 * time.Millisecond,
+			},
+			defaultRounds: 8,
+			rounds:        8,
+		},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			pool := newFakePool("main")
+			pool.On(`^SELECT .*NTH_PLAN\(3\)`).Return(result).Delay(testCase.delays[len(testCase.delays)-1])
+			// the latest registered response answers first
+			for i := len(testCase.delays) - 1; i >= 0; i-- {
+				pool.On(`^SELECT .*NTH_PLAN\(3\)`).Return(result).Delay(testCase.delays[i]).Times(1)
+			}
+			horo := NewHoroscope(pool, nil, &queries{"SELECT a FROM t"}, false, Options{MaxRounds: 8})
+
+			benches, err := horo.Next(3, 10, true, false)
+			require.Nil(t, err)
+			require.Len(t, benches.Plans, 2)
+			assert.Len(t, benches.DefaultPlan.Cost.Values, testCase.defaultRounds)
+			assert.Len(t, benches.Plans[0].Cost.Values, 3)
+			assert.Len(t, benches.Plans[1].Cost.Values, testCase.rounds)
+			assert.Equal(t, testCase.better, IsSubOptimal(&benches.DefaultPlan, benches.Plans[1]))
+
+			collection := BenchCollection{benches}
+			row := collection.Table().Rows[0]
+			assert.Equal(t, testCase.defaultRounds, row.DefaultPlanRounds)
+			require.Len(t, row.PlanTests, 2)
+			assert.Equal(t, testCase.rounds, row.PlanTests[1].Rounds)
+			require.NotNil(t, row.PlanTests[1].PValue)
+			assert.Equal(t, testCase.significant, *row.PlanTests[1].PValue < 0.05)
+		})
+	}
+}
+
+func TestHoroscope_NextWithWarmup(t *testing.T) {
+	for _, interleave := range []bool{false, true} {
+		pool := newFakePool("main")
+		horo := NewHoroscope(pool, nil, &queries{"SELECT a FROM t"}, false, Options{Warmup: 2, Outlier: OutlierMAD, Interleave: interleave})
+
+		benches, err := horo.Next(3, 10, true, false)
+		require.Nil(t, err)
+		executions := make(map[string]int)
+		for _, statement := range pool.Statements() {
+			if strings.HasPrefix(statement, "SELECT") {
+				executions[statement]++
+			}
+		}
+		assert.Equal(t, 5, executions[benches.DefaultPlan.SQL])
+		assert.Len(t, benches.DefaultPlan.Cost.Values, 3)
+		for _, plan := range benches.Plans {
+			assert.Equal(t, 5, executions[plan.SQL])
+			assert.Len(t, plan.Cost.Values, 3)
+		}
+	}
+	// plans are warmed up again before rounds added to them, other plans ran in between
+	horo := NewHoroscope(newFakePool("main"), nil, nil, false, Options{Warmup: 2})
+	var rounds []uint
+	run := func(exec executor.Executor, round uint, sql string, timeout time.Duration) (*Metrics, []executor.Comparable, error) {
+		rounds = append(rounds, round)
+		return &Metrics{Unit: "ms", Values: []float64{10, 11, 12}[:round]}, nil, nil
+	}
+	plan := &Bench{Plan: 3, Cost: &Metrics{Unit: "ms", Values: []float64{10, 11, 12}}}
+	require.True(t, horo.extend(nil, run, plan, 3, 0))
+	assert.Equal(t, []uint{2, 3}, rounds)
+	assert.Len(t, plan.Cost.Values, 6)
+}
@@ -0,0 +1,133 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package horoscope
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"sync"
+	"time"
+)
+
+// JournalMode decides what OpenJournal does with an existing journal
+type JournalMode uint8
+
+const (
+	// JournalNew starts a journal, it refuses a journal with finished queries
+	JournalNew JournalMode = iota
+	// JournalResume reads rows of the journal and appends to it
+	JournalResume
+	// JournalRotate renames a journal with finished queries to `<name>.<unix time>` and starts a new one
+	JournalRotate
+)
+
+type (
+	// Journal appends report rows of finished queries to a file line by line, so that a test run can be resumed
+	// and its report rebuilt after a crash
+	Journal struct {
+		mu   sync.Mutex
+		file *os.File
+	}
+
+	// journalEntry is a line of the journal
+	journalEntry struct {
+		Row *Row `json:"row"`
+		// EstRowsQError is the one of Row without NaN values, which cannot be encoded in json
+		EstRowsQError map[string]float64 `json:"estRowsQError"`
+	}
+)
+
+// OpenJournal opens the journal at name by the mode, rows are the finished queries read by JournalResume.
+// A torn last line left by a crash is dropped.
+func OpenJournal(name string, mode JournalMode) (journal *Journal, rows []*Row, err error) {
+	flag := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	switch mode {
+	case JournalResume:
+		if rows, err = readJournal(name); err != nil {
+			return
+		}
+	default:
+		var info os.FileInfo
+		if info, err = os.Stat(name); err == nil && info.Size() > 0 {
+			if mode != JournalRotate {
+				return nil, nil, fmt.Errorf("journal %s has finished queries", name)
+			}
+			if err = os.Rename(name, fmt.Sprintf("%s.%d", name, time.Now().Unix())); err != nil {
+				return
+			}
+		} else if err != nil && !os.IsNotExist(err) {
+			return
+		}
+		flag |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(name, flag, 0644)
+	if err != nil {
+		return
+	}
+	return &Journal{file: file}, rows, nil
+}
+
+func readJournal(name string) (rows []*Row, err error) {
+	data, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return
+	}
+	offset := 0
+	for line := 1; offset < len(data); line++ {
+		end := bytes.IndexByte(data[offset:], '\n')
+		var entry journalEntry
+		if end < 0 || json.Unmarshal(data[offset:offset+end], &entry) != nil || entry.Row == nil {
+			if end >= 0 && offset+end+1 < len(data) {
+				return nil, fmt.Errorf("corrupted line %d of journal %s", line, name)
+			}
+			return rows, os.Truncate(name, int64(offset))
+		}
+		entry.Row.EstRowsQError = entry.EstRowsQError
+		rows = append(rows, entry.Row)
+		offset += end + 1
+	}
+	return
+}
+
+// Append writes the row of benches and syncs it to the disk
+func (j *Journal) Append(benches *Benches) error {
+	row := newRow(benches)
+	entry := journalEntry{Row: row, EstRowsQError: make(map[string]float64, len(row.EstRowsQError))}
+	for key, value := range row.EstRowsQError {
+		if !math.IsNaN(value) && !math.IsInf(value, 0) {
+			entry.EstRowsQError[key] = value
+		}
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err = j.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+func (j *Journal) Close() error {
+	return j.file.Close()
+}
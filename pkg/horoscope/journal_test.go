@@ -0,0 +1,95 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package horoscope
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "journal.jsonl")
+	newBenches := func(qID string) *Benches {
+		return &Benches{
+			QueryID:     qID,
+			DefaultPlan: Bench{Plan: 1, SQL: "SELECT a FROM t", Cost: metrics(20, 21, 19)},
+			Plans: []*Bench{
+				{Plan: 1, SQL: "SELECT /*+ NTH_PLAN(1) */ a FROM t", Cost: metrics(20, 21, 19)},
+				{Plan: 2, SQL: "SELECT /*+ NTH_PLAN(2) */ a FROM t", Cost: metrics(5, 6, 5)},
+			},
+		}
+	}
+
+	journal, rows, err := OpenJournal(name, JournalResume)
+	require.Nil(t, err)
+	assert.Empty(t, rows)
+	require.Nil(t, journal.Append(newBenches("q1")))
+	require.Nil(t, journal.Append(newBenches("q2")))
+	require.Nil(t, journal.Close())
+
+	// a torn line is dropped and overwritten by later rows
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0644)
+	require.Nil(t, err)
+	_, err = file.WriteString(`{"row":{"queryID":"q3"`)
+	require.Nil(t, err)
+	require.Nil(t, file.Close())
+
+	journal, rows, err = OpenJournal(name, JournalResume)
+	require.Nil(t, err)
+	require.Len(t, rows, 2)
+	assert.Equal(t, "q1", rows[0].QueryId)
+	assert.Equal(t, "q2", rows[1].QueryId)
+	assert.Equal(t, []string{"#2(26.7%)"}, rows[1].OptimalPlan)
+	assert.Equal(t, 0.0, rows[1].EstRowsQError["count"])
+	require.Nil(t, journal.Append(newBenches("q3")))
+	require.Nil(t, journal.Close())
+
+	journal, rows, err = OpenJournal(name, JournalResume)
+	require.Nil(t, err)
+	require.Len(t, rows, 3)
+	assert.Equal(t, "q3", rows[2].QueryId)
+	require.Nil(t, journal.Close())
+
+	// a new run truncates the journal
+	// finished queries are neither overwritten nor lost
+	_, _, err = OpenJournal(name, JournalNew)
+	assert.NotNil(t, err)
+	journal, rows, err = OpenJournal(name, JournalRotate)
+	require.Nil(t, err)
+	assert.Empty(t, rows)
+	require.Nil(t, journal.Close())
+	rotated, err := filepath.Glob(name + ".*")
+	require.Nil(t, err)
+	require.Len(t, rotated, 1)
+	journal, rows, err = OpenJournal(name, JournalNew)
+	require.Nil(t, err)
+	assert.Empty(t, rows)
+	require.Nil(t, journal.Close())
+	journal, rows, err = OpenJournal(name, JournalResume)
+	require.Nil(t, err)
+	assert.Empty(t, rows)
+	require.Nil(t, journal.Close())
+
+	require.Nil(t, ioutil.WriteFile(name, []byte("{\n{}\n"), 0644))
+	_, _, err = OpenJournal(name, JournalResume)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,233 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package horoscope
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+
+	"github.com/aclements/go-moremath/stats"
+	"golang.org/x/perf/benchstat"
+)
+
+const (
+	// DefaultAlpha is the significance level of judges by default
+	DefaultAlpha = 0.05
+	// DefaultThreshold is the ratio to the cost of the default plan a better plan must be lower than by default
+	DefaultThreshold = 0.9
+)
+
+// bootstrapResamples is the count of resamples of the bootstrap judge
+const bootstrapResamples = 1000
+
+// bootstrapSeed makes confidence intervals of the bootstrap judge reproducible
+const bootstrapSeed = 1
+
+// Judges are names of judges for NewJudge
+var Judges = []string{"ttest", "utest", "bootstrap", "threshold"}
+
+// DefaultJudge is the Welch t-test with the default alpha and threshold
+var DefaultJudge = NewTTestJudge(DefaultAlpha, DefaultThreshold)
+
+type (
+	// Judge decides whether a plan is better than the default plan by their costs
+	Judge interface {
+		// Judge compares costs of a plan with costs of the default plan, both of them have samples
+		Judge(defaultCost, cost *Metrics) Verdict
+		// String describes the judge with its parameters in reports
+		String() string
+	}
+
+	// Verdict is the decision of a judge on a plan
+	Verdict struct {
+		// Better means the plan is better than the default plan
+		Better bool
+		// Undecided means more rounds may change the verdict
+		Undecided bool
+		// PValue is the p-value of the statistical test, nil if the judge has no p-value or the test fails
+		PValue *float64
+	}
+
+	// testJudge takes a plan as better if its mean cost is lower than the threshold and the difference
+	// is significant by the test, or the test fails
+	testJudge struct {
+		name      string
+		alpha     float64
+		threshold float64
+		test      func(old, new *benchstat.Metrics) (float64, error)
+		// looks is the count of tests alpha is split across, zero means a single test
+		looks uint
+	}
+
+	// bootstrapJudge takes a plan as better if the upper bound of the confidence interval of the ratio of
+	// its median cost to the median cost of the default plan is lower than the threshold
+	bootstrapJudge struct {
+		alpha     float64
+		threshold float64
+		looks     uint
+	}
+
+	// thresholdJudge takes a plan as better if its mean cost is lower than the threshold
+	thresholdJudge struct {
+		threshold float64
+	}
+)
+
+// NewTTestJudge judges plans by the Welch t-test
+func NewTTestJudge(alpha, threshold float64) Judge {
+	return &testJudge{name: "t-test", alpha: alpha, threshold: threshold, test: benchstat.TTest}
+}
+
+// NewUTestJudge judges plans by the Mann-Whitney U-test
+func NewUTestJudge(alpha, threshold float64) Judge {
+	return &testJudge{name: "u-test", alpha: alpha, threshold: threshold, test: benchstat.UTest}
+}
+
+// NewBootstrapJudge judges plans by the bootstrap confidence interval at level 1-alpha on the ratio of medians
+func NewBootstrapJudge(alpha, threshold float64) Judge {
+	return &bootstrapJudge{alpha: alpha, threshold: threshold}
+}
+
+// NewThresholdJudge judges plans by the ratio of mean costs only
+func NewThresholdJudge(threshold float64) Judge {
+	return &thresholdJudge{threshold: threshold}
+}
+
+// NewJudge returns the judge of the name in Judges
+func NewJudge(name string, alpha, threshold float64) (Judge, error) {
+	switch name {
+	case "ttest":
+		return NewTTestJudge(alpha, threshold), nil
+	case "utest":
+		return NewUTestJudge(alpha, threshold), nil
+	case "bootstrap":
+		return NewBootstrapJudge(alpha, threshold), nil
+	case "threshold":
+		return NewThresholdJudge(threshold), nil
+	default:
+		return nil, fmt.Errorf("unknown judge: %s", name)
+	}
+}
+
+// SequentialJudge splits alpha of the judge evenly across the looks of MaxRounds, `round` rounds a look, so that
+// judging plans again after each look keeps the significance level. Judges without alpha are returned as is
+func SequentialJudge(judge Judge, round, maxRounds uint) Judge {
+	if judge == nil {
+		judge = DefaultJudge
+	}
+	if round == 0 || maxRounds <= round {
+		return judge
+	}
+	looks := 1 + (maxRounds-round+round-1)/round
+	switch j := judge.(type) {
+	case *testJudge:
+		corrected := *j
+		corrected.alpha, corrected.looks = j.alpha/float64(looks), looks
+		return &corrected
+	case *bootstrapJudge:
+		corrected := *j
+		corrected.alpha, corrected.looks = j.alpha/float64(looks), looks
+		return &corrected
+	default:
+		return judge
+	}
+}
+
+// judgePlan gives the verdict of judge on the plan, plans failed or censored are never better
+func judgePlan(judge Judge, defPlan *Bench, plan *Bench) Verdict {
+	if plan.Cost == nil || plan.Censored || defPlan.Cost == nil {
+		return Verdict{}
+	}
+	return judge.Judge(defPlan.Cost, plan.Cost)
+}
+
+func (j *testJudge) Judge(defaultCost, cost *Metrics) (verdict Verdict) {
+	pVal, err := j.test((*benchstat.Metrics)(defaultCost), (*benchstat.Metrics)(cost))
+	if err == nil {
+		verdict.PValue = &pVal
+	}
+	if err != nil {
+		// the test is not applicable, like with zero variances, more rounds are not asked for the threshold-only verdict
+		verdict.Better = cost.Mean < j.threshold*defaultCost.Mean
+		return
+	}
+	significant := pVal < j.alpha
+	verdict.Better = cost.Mean < j.threshold*defaultCost.Mean && significant
+	// plans slower than the default plan on average are decided
+	verdict.Undecided = cost.Mean < defaultCost.Mean && !significant
+	return
+}
+
+func (j *testJudge) String() string {
+	return fmt.Sprintf("%s(alpha=%g%s, threshold=%g)", j.name, j.alpha, looksString(j.looks), j.threshold)
+}
+
+func (j *bootstrapJudge) Judge(defaultCost, cost *Metrics) (verdict Verdict) {
+	defaults, values := samples(defaultCost), samples(cost)
+	ratio := median(values) / median(defaults)
+	if len(defaults) < 2 || len(values) < 2 {
+		return Verdict{Better: ratio < j.threshold, Undecided: ratio >= j.threshold && ratio < 1}
+	}
+	rng := rand.New(rand.NewSource(bootstrapSeed))
+	ratios := make([]float64, 0, bootstrapResamples)
+	for i := 0; i < bootstrapResamples; i++ {
+		ratios = append(ratios, median(resample(rng, values))/median(resample(rng, defaults)))
+	}
+	sort.Float64s(ratios)
+	interval := stats.Sample{Xs: ratios, Sorted: true}
+	lo, hi := interval.Quantile(j.alpha/2), interval.Quantile(1-j.alpha/2)
+	verdict.Better = hi < j.threshold
+	verdict.Undecided = ratio < 1 && lo < j.threshold && hi >= j.threshold
+	return
+}
+
+func (j *bootstrapJudge) String() string {
+	return fmt.Sprintf("bootstrap(alpha=%g%s, threshold=%g)", j.alpha, looksString(j.looks), j.threshold)
+}
+
+func looksString(looks uint) string {
+	if looks == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" over %d looks", looks)
+}
+
+func (j *thresholdJudge) Judge(defaultCost, cost *Metrics) Verdict {
+	return Verdict{Better: cost.Mean < j.threshold*defaultCost.Mean}
+}
+
+func (j *thresholdJudge) String() string {
+	return fmt.Sprintf("threshold(threshold=%g)", j.threshold)
+}
+
+// samples are values of m kept by the outlier policy
+func samples(m *Metrics) []float64 {
+	if len(m.RValues) != 0 {
+		return m.RValues
+	}
+	return m.Values
+}
+
+func median(values []float64) float64 {
+	return stats.Sample{Xs: values}.Quantile(0.5)
+}
+
+func resample(rng *rand.Rand, values []float64) []float64 {
+	sample := make([]float64, len(values))
+	for i := range sample {
+		sample[i] = values[rng.Intn(len(values))]
+	}
+	return sample
+}
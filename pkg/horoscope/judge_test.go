@@ -0,0 +1,111 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package horoscope
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func metrics(values ...float64) *Metrics {
+	m := &Metrics{Unit: "ms", Values: values}
+	m.computeStats(OutlierIQR)
+	return m
+}
+
+func TestJudges(t *testing.T) {
+	defaultCost := metrics(20, 21, 19, 20, 22, 20)
+	costs := map[string]*Metrics{
+		"better": metrics(5, 6, 5, 4, 5, 6),
+		"slower": metrics(30, 31, 29, 30, 32, 30),
+		"noisy":  metrics(5, 25, 5, 25, 5, 25),
+	}
+	for _, testCase := range []struct {
+		judge     string
+		better    []string
+		undecided []string
+		pValue    bool
+	}{
+		{judge: "ttest", better: []string{"better"}, undecided: []string{"noisy"}, pValue: true},
+		{judge: "utest", better: []string{"better"}, undecided: []string{"noisy"}, pValue: true},
+		{judge: "bootstrap", better: []string{"better"}, undecided: []string{"noisy"}},
+		{judge: "threshold", better: []string{"better", "noisy"}},
+	} {
+		judge, err := NewJudge(testCase.judge, DefaultAlpha, DefaultThreshold)
+		require.Nil(t, err)
+		for name, cost := range costs {
+			verdict := judge.Judge(defaultCost, cost)
+			assert.Equal(t, contains(testCase.better, name), verdict.Better, "%s: %s", judge, name)
+			assert.Equal(t, contains(testCase.undecided, name), verdict.Undecided, "%s: %s", judge, name)
+			assert.Equal(t, testCase.pValue, verdict.PValue != nil, "%s: %s", judge, name)
+		}
+	}
+
+	_, err := NewJudge("unknown", DefaultAlpha, DefaultThreshold)
+	assert.NotNil(t, err)
+	assert.Equal(t, "t-test(alpha=0.05, threshold=0.9)", DefaultJudge.String())
+	assert.Equal(t, "threshold(threshold=0.8)", NewThresholdJudge(0.8).String())
+}
+
+func TestJudgesWithoutTest(t *testing.T) {
+	// zero variances fail the t-test and single rounds are too few for the bootstrap
+	for _, testCase := range []struct {
+		judge                string
+		defaultCost, cheaper *Metrics
+	}{
+		{"ttest", metrics(20, 20, 20), metrics(5, 5, 5)},
+		{"bootstrap", metrics(20), metrics(5)},
+	} {
+		judge, err := NewJudge(testCase.judge, DefaultAlpha, DefaultThreshold)
+		require.Nil(t, err)
+		verdict := judge.Judge(testCase.defaultCost, testCase.cheaper)
+		assert.True(t, verdict.Better, testCase.judge)
+		assert.False(t, verdict.Undecided, testCase.judge)
+	}
+}
+
+func TestSequentialJudge(t *testing.T) {
+	// rounds 2, 4, 6 and 7 are judged
+	assert.Equal(t, "t-test(alpha=0.0125 over 4 looks, threshold=0.9)", SequentialJudge(nil, 2, 7).String())
+	assert.Equal(t, "bootstrap(alpha=0.025 over 2 looks, threshold=0.8)", SequentialJudge(NewBootstrapJudge(0.05, 0.8), 3, 6).String())
+	assert.Equal(t, "u-test(alpha=0.05, threshold=0.9)", SequentialJudge(NewUTestJudge(0.05, 0.9), 3, 3).String())
+	assert.Equal(t, "threshold(threshold=0.8)", SequentialJudge(NewThresholdJudge(0.8), 2, 7).String())
+	// the judge itself is not changed
+	assert.Equal(t, "t-test(alpha=0.05, threshold=0.9)", DefaultJudge.String())
+}
+
+func TestBenches_Verdict(t *testing.T) {
+	benches := &Benches{DefaultPlan: Bench{Cost: metrics(20, 21, 19)}}
+	for _, plan := range []*Bench{
+		{Plan: 1},
+		{Plan: 2, Cost: metrics(5, 5, 5), Censored: true},
+	} {
+		assert.Equal(t, Verdict{}, benches.Verdict(plan))
+	}
+	plan := &Bench{Plan: 3, Cost: metrics(12, 17, 23)}
+	assert.False(t, benches.Verdict(plan).Better)
+	benches.Judge = NewThresholdJudge(0.9)
+	assert.True(t, benches.Verdict(plan).Better)
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
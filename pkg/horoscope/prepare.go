@@ -0,0 +1,144 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package horoscope
+
+import (
+	"github.com/pingcap/parser"
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/format"
+
+	"github.com/chaos-mesh/horoscope/pkg/utils"
+)
+
+// ParameterizedQuery is a query whose literals are lifted out as parameters of a prepared statement
+type ParameterizedQuery struct {
+	// SQL has a `?` in place of each lifted literal
+	SQL string
+	// Params are values of the literals in the order of placeholders
+	Params   []interface{}
+	node     ast.StmtNode
+	literals []ast.ValueExpr
+}
+
+// paramExpr restores its literal as a placeholder and records the literal, so that placeholders and
+// parameters are in the same order
+type paramExpr struct {
+	ast.ValueExpr
+	query *ParameterizedQuery
+}
+
+func (p *paramExpr) Restore(ctx *format.RestoreCtx) error {
+	ctx.WritePlain("?")
+	p.query.literals = append(p.query.literals, p.ValueExpr)
+	p.query.Params = append(p.query.Params, p.GetValue())
+	return nil
+}
+
+func (p *paramExpr) Accept(v ast.Visitor) (ast.Node, bool) {
+	node, _ := v.Enter(p)
+	return v.Leave(node)
+}
+
+// literalLifter wraps literals in paramExpr, literals in select fields are kept because they name result columns
+type literalLifter struct {
+	query *ParameterizedQuery
+}
+
+func (l *literalLifter) Enter(n ast.Node) (ast.Node, bool) {
+	switch node := n.(type) {
+	case *ast.SelectField, *ast.FrameBound:
+		return n, true
+	case ast.ValueExpr:
+		if liftable(node.GetValue()) {
+			return &paramExpr{ValueExpr: node, query: l.query}, true
+		}
+	}
+	return n, false
+}
+
+func (l *literalLifter) Leave(n ast.Node) (ast.Node, bool) {
+	return n, true
+}
+
+// literalRestorer unwraps paramExpr
+type literalRestorer struct{}
+
+func (literalRestorer) Enter(n ast.Node) (ast.Node, bool) {
+	if param, ok := n.(*paramExpr); ok {
+		return param.ValueExpr, true
+	}
+	return n, false
+}
+
+func (literalRestorer) Leave(n ast.Node) (ast.Node, bool) {
+	return n, true
+}
+
+// liftable values can be sent by the binary protocol without changing their types
+func liftable(value interface{}) bool {
+	switch value.(type) {
+	case int64, uint64, float64, string, []byte:
+		return true
+	default:
+		return false
+	}
+}
+
+// Parameterize parses the query and lifts its literals out
+func Parameterize(sql string) (query *ParameterizedQuery, err error) {
+	node, err := parser.New().ParseOneStmt(sql, "", "")
+	if err != nil {
+		return
+	}
+	query = &ParameterizedQuery{node: node}
+	node.Accept(&literalLifter{query: query})
+	query.SQL, err = utils.BufferOut(node)
+	node.Accept(literalRestorer{})
+	return
+}
+
+// ParamSets returns n sets of parameters, the first one is the original, integers in the i-th set are
+// shifted by i so that a cached plan is reused with different values
+func (q *ParameterizedQuery) ParamSets(n int) [][]interface{} {
+	sets := make([][]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		set := make([]interface{}, 0, len(q.Params))
+		for _, param := range q.Params {
+			switch value := param.(type) {
+			case int64:
+				param = value + int64(i)
+			case uint64:
+				param = value + uint64(i)
+			}
+			set = append(set, param)
+		}
+		sets = append(sets, set)
+	}
+	return sets
+}
+
+// Inline restores the query with params in place of the lifted literals
+func (q *ParameterizedQuery) Inline(params []interface{}) (string, error) {
+	originals := make([]interface{}, 0, len(q.literals))
+	for i, literal := range q.literals {
+		originals = append(originals, literal.GetValue())
+		literal.SetValue(params[i])
+	}
+	defer func() {
+		for i, literal := range q.literals {
+			literal.SetValue(originals[i])
+		}
+	}()
+	return utils.BufferOut(q.node)
+}
@@ -0,0 +1,40 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package horoscope
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParameterize(t *testing.T) {
+	query, err := Parameterize("SELECT a, 1 AS one FROM t WHERE b > 10 AND c = 'x' AND d < 1.5 ORDER BY a LIMIT 5")
+	require.Nil(t, err)
+	assert.Equal(t, "SELECT a,1 AS one FROM t WHERE b>? AND c=? AND d<1.5 ORDER BY a LIMIT ?", query.SQL)
+	assert.Equal(t, []interface{}{int64(10), "x", uint64(5)}, query.Params)
+
+	sets := query.ParamSets(2)
+	require.Len(t, sets, 2)
+	assert.Equal(t, query.Params, sets[0])
+	assert.Equal(t, []interface{}{int64(11), "x", uint64(6)}, sets[1])
+
+	sql, err := query.Inline(sets[1])
+	require.Nil(t, err)
+	assert.Equal(t, "SELECT a,1 AS one FROM t WHERE b>11 AND c=\"x\" AND d<1.5 ORDER BY a LIMIT 6", sql)
+	sql, err = query.Inline(sets[0])
+	require.Nil(t, err)
+	assert.Equal(t, "SELECT a,1 AS one FROM t WHERE b>10 AND c=\"x\" AND d<1.5 ORDER BY a LIMIT 5", sql)
+}
@@ -16,52 +16,123 @@ package horoscope
 import (
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/jedib0t/go-pretty/table"
+
+	"github.com/chaos-mesh/horoscope/pkg/executor"
 )
 
 // Table is used for displaying in output
 type Table struct {
-	Metric  string   `json:"metric"`
-	Headers []string `json:"-"`
-	Rows    []*Row   `json:"data"`
+	Metric   string    `json:"metric"`
+	Judge    string    `json:"judge"`
+	Headers  []string  `json:"-"`
+	Rows     []*Row    `json:"data"`
+	Sessions []Session `json:"sessions,omitempty"`
+}
+
+// Session records session variables of a DSN without the password, so that the report is reproducible
+type Session struct {
+	Dsn       string            `json:"dsn"`
+	Variables map[string]string `json:"variables"`
 }
 
 type Row struct {
-	QueryId           string             `json:"queryID"`
-	Query             string             `json:"query"`
-	PlanSpaceCount    int                `json:"planSpaceSize"`
-	DefaultPlanId     int                `json:"defaultPlanID"`
-	DefaultPlanDur    float64            `json:"defaultPlanDur"`
-	DefaultPlanDurDev float64            `json:"defaultPlanDurDev"`
-	BestPlanDur       float64            `json:"bestPlanDur"`
-	BestPlanDurDev    float64            `json:"bestPlanDurDev"`
-	OptimalPlan       []string           `json:"optimalPlan"`
-	Effectiveness     float64            `json:"effectiveness"`
-	EstRowsQError     map[string]float64 `json:"-"`
+	QueryId           string         `json:"queryID"`
+	Query             string         `json:"query"`
+	PlanSpaceCount    int            `json:"planSpaceSize"`
+	RawPlanSpaceCount int            `json:"rawPlanSpaceSize"`
+	DefaultPlanId     int            `json:"defaultPlanID"`
+	DefaultPlanDur    float64        `json:"defaultPlanDur"`
+	DefaultPlanDurDev float64        `json:"defaultPlanDurDev"`
+	BestPlanDur       float64        `json:"bestPlanDur"`
+	BestPlanDurDev    float64        `json:"bestPlanDurDev"`
+	DefaultPlanServer *ServerMetrics `json:"defaultPlanServer,omitempty"`
+	BestPlanServer    *ServerMetrics `json:"bestPlanServer,omitempty"`
+	OptimalPlan       []string       `json:"optimalPlan"`
+	DefaultPlanRounds int            `json:"defaultPlanRounds"`
+	PlanTests         []PlanTest     `json:"planTests"`
+	DefaultRejected   []float64      `json:"defaultRejected,omitempty"`
+	BestPlanHintDiff  string         `json:"bestPlanHintDiff,omitempty"`
+	CaptureDir        string         `json:"captureDir,omitempty"`
+	CensoredPlan      []string       `json:"censoredPlan"`
+	CrashedPlan       []string       `json:"crashedPlan"`
+	DominantOperator  string         `json:"dominantOperator"`
+	PlanCacheHits     string         `json:"planCacheHits"`
+	Retries           []Retry        `json:"retries"`
+	// TimedOut means the default plan timed out and the query was skipped
+	TimedOut      bool               `json:"timedOut,omitempty"`
+	Effectiveness float64            `json:"effectiveness"`
+	EstRowsQError map[string]float64 `json:"-"`
+}
+
+// PlanTest is the judgement of a measured plan against the default plan
+type PlanTest struct {
+	Plan   uint64 `json:"plan"`
+	Rounds int    `json:"rounds"`
+	// PValue is nil if the judge has no p-value or the test fails, like with zero variances
+	PValue *float64 `json:"pValue"`
+	// Rejected are samples of costs rejected by the outlier policy
+	Rejected []float64 `json:"rejected,omitempty"`
+}
+
+func (t PlanTest) String() string {
+	if t.PValue == nil {
+		return fmt.Sprintf("#%d(%d, p=n/a)", t.Plan, t.Rounds)
+	}
+	return fmt.Sprintf("#%d(%d, p=%.3f)", t.Plan, t.Rounds, *t.PValue)
+}
+
+func planTests(defaultRounds int, tests []PlanTest) string {
+	items := []string{fmt.Sprintf("default(%d)", defaultRounds)}
+	for _, test := range tests {
+		items = append(items, test.String())
+	}
+	return strings.Join(items, ",")
 }
 
 func (r *Row) toTableRows() table.Row {
+	defaultDur := fmt.Sprintf("%2d: %.1f ± %.1f%%", r.DefaultPlanId, r.DefaultPlanDur, r.DefaultPlanDurDev)
+	if r.TimedOut {
+		defaultDur = fmt.Sprintf("%2d: timed out", r.DefaultPlanId)
+	}
 	var row table.Row
-	row = append(row, r.QueryId, r.PlanSpaceCount, fmt.Sprintf("%2d: %.1f ± %.1f%%", r.DefaultPlanId, r.DefaultPlanDur, r.DefaultPlanDurDev),
-		fmt.Sprintf("%.1f ± %.1f%%", r.BestPlanDur, r.BestPlanDurDev),
-		fmt.Sprintf("%.1f%%", r.Effectiveness*100), strings.Join(r.OptimalPlan, ","),
+	row = append(row, r.QueryId, fmt.Sprintf("%d/%d", r.PlanSpaceCount, r.RawPlanSpaceCount), defaultDur,
+		fmt.Sprintf("%.1f ± %.1f%%", r.BestPlanDur, r.BestPlanDurDev), r.DefaultPlanServer.String(),
+		fmt.Sprintf("%.1f%%", r.Effectiveness*100), strings.Join(r.OptimalPlan, ","), planTests(r.DefaultPlanRounds, r.PlanTests), strings.Join(r.CensoredPlan, ","), strings.Join(r.CrashedPlan, ","), r.DominantOperator, r.PlanCacheHits, retries(r.Retries),
 		fmt.Sprintf("count: %d, median: %.1f, 90th:%.1f, 95th:%.1f, max:%.1f", int(r.EstRowsQError["count"]), r.EstRowsQError["median"],
 			r.EstRowsQError["90th"], r.EstRowsQError["95th"], r.EstRowsQError["max"]),
 		r.Query)
 	return row
 }
 
+func retries(retries []Retry) string {
+	items := make([]string, 0, len(retries))
+	for _, retry := range retries {
+		items = append(items, retry.String())
+	}
+	return strings.Join(items, ",")
+}
+
 type BenchCollection []*Benches
 
-func (c *BenchCollection) Output(format string) error {
+func (c *BenchCollection) Output(format string, sessions []Session) error {
+	table := c.Table()
+	table.Sessions = sessions
+	return table.Output(format)
+}
+
+// Output prints the table in the format of `table` or `json`
+func (t Table) Output(format string) error {
 	switch format {
 	case "table":
-		fmt.Println(c.Table().String())
+		fmt.Println(t.String())
 		return nil
 	case "json":
-		data, err := json.Marshal(c.Table())
+		data, err := json.Marshal(t)
 		if err != nil {
 			return err
 		}
@@ -73,51 +144,142 @@ func (c *BenchCollection) Output(format string) error {
 }
 
 func (c *BenchCollection) Table() Table {
-	table := Table{Metric: "execution time", Headers: []string{"id", "#plan space", "default execution time", "best plan execution time", "effectiveness", "better optimal plans", "estRow q-error", "query"}}
+	table := Table{Metric: "execution time", Headers: []string{"id", "#plan space(distinct/raw)", "default execution time", "best plan execution time", "default server metrics", "effectiveness", "better optimal plans", "plan tests", "censored plans", "crashed plans", "dominant operator", "plan cache hits", "retries", "estRow q-error", "query"}, Judge: DefaultJudge.String()}
 	for _, b := range *c {
-		defaultPlan, bestPlan, betterPlanCount, optimalPlan := &b.DefaultPlan, &b.DefaultPlan, 0, make([]string, 0)
-		baseTableBookMap, baseTableMetrics := make(map[string]struct{}), Metrics{}
-		for _, p := range b.Plans {
-			if IsSubOptimal(defaultPlan, p) {
-				betterPlanCount += 1
-				optimalPlan = append(optimalPlan, fmt.Sprintf("#%d(%.1f%%)", p.Plan, 100*p.Cost.Mean/defaultPlan.Cost.Mean))
-				if p.Cost.Mean < bestPlan.Cost.Mean {
-					bestPlan = p
-				}
-			}
-			for _, c := range p.BaseTableCardInfo {
-				if _, ok := baseTableBookMap[c.OpInfo]; !ok {
-					baseTableBookMap[c.OpInfo] = struct{}{}
-					baseTableMetrics.Values = append(baseTableMetrics.Values, c.QError)
-				}
-			}
+		if b.Judge != nil {
+			table.Judge = b.Judge.String()
 		}
-		planSpaceCount := len(b.Plans)
-		row := Row{
+		table.Rows = append(table.Rows, newRow(b))
+	}
+	return table
+}
+
+// newRow summarizes the benches of a query
+func newRow(b *Benches) *Row {
+	if b.DefaultPlan.Cost == nil {
+		return &Row{
 			QueryId:           b.QueryID,
 			Query:             b.DefaultPlan.SQL,
 			PlanSpaceCount:    len(b.Plans),
+			RawPlanSpaceCount: b.RawPlanCount,
 			DefaultPlanId:     int(b.DefaultPlan.Plan),
-			DefaultPlanDur:    b.DefaultPlan.Cost.Mean,
-			DefaultPlanDurDev: b.DefaultPlan.Cost.Diff(),
-			BestPlanDur:       bestPlan.Cost.Mean,
-			BestPlanDurDev:    bestPlan.Cost.Diff(),
-			OptimalPlan:       optimalPlan,
-			Effectiveness:     float64(planSpaceCount-betterPlanCount) / float64(planSpaceCount),
-			EstRowsQError: map[string]float64{
-				"count": float64(len(baseTableMetrics.Values)),
-				"50th":  baseTableMetrics.quantile(0.5),
-				"90th":  baseTableMetrics.quantile(0.5),
-				"95th":  baseTableMetrics.quantile(0.5),
-				"max":   baseTableMetrics.quantile(1),
-			},
+			OptimalPlan:       make([]string, 0),
+			PlanTests:         make([]PlanTest, 0),
+			CensoredPlan:      make([]string, 0),
+			CrashedPlan:       make([]string, 0),
+			Retries:           b.Retries,
+			TimedOut:          b.DefaultPlan.TimedOut,
+			EstRowsQError:     map[string]float64{},
 		}
-		table.Rows = append(table.Rows, &row)
 	}
-	return table
+	defaultPlan, bestPlan, betterPlanCount, optimalPlan := &b.DefaultPlan, &b.DefaultPlan, 0, make([]string, 0)
+	censoredPlan, crashedPlan, tests := make([]string, 0), make([]string, 0), make([]PlanTest, 0)
+	baseTableBookMap, baseTableMetrics := make(map[string]struct{}), Metrics{}
+	for _, p := range b.Plans {
+		// censored plans are slower than the default plan, they count in plan space but never be better
+		if p.Censored {
+			censoredPlan = append(censoredPlan, fmt.Sprintf("#%d(>%.1f%%)", p.Plan, 100*p.Cost.Mean/defaultPlan.Cost.Mean))
+		}
+		if p.Crashed {
+			crashedPlan = append(crashedPlan, fmt.Sprintf("#%d", p.Plan))
+		}
+		if p.Cost != nil && !p.Censored {
+			test := PlanTest{Plan: p.Plan, Rounds: len(p.Cost.Values), Rejected: p.Cost.Rejected()}
+			test.PValue = b.Verdict(p).PValue
+			tests = append(tests, test)
+		}
+		if b.Verdict(p).Better {
+			betterPlanCount += 1
+			optimalPlan = append(optimalPlan, fmt.Sprintf("#%d(%.1f%%)", p.Plan, 100*p.Cost.Mean/defaultPlan.Cost.Mean))
+			if p.Cost.Mean < bestPlan.Cost.Mean {
+				bestPlan = p
+			}
+		}
+		for _, c := range p.BaseTableCardInfo {
+			if _, ok := baseTableBookMap[c.OpInfo]; !ok {
+				baseTableBookMap[c.OpInfo] = struct{}{}
+				baseTableMetrics.Values = append(baseTableMetrics.Values, c.QError)
+			}
+		}
+	}
+	planSpaceCount := len(b.Plans)
+	var planCacheHits string
+	if b.Prepared != nil {
+		planCacheHits = fmt.Sprintf("%d/%d", b.Prepared.CacheHits(), len(b.Prepared.Executions))
+	}
+	var captureDir string
+	if b.Capture != nil {
+		captureDir = b.Capture.Dir
+	}
+	row := Row{
+		QueryId:           b.QueryID,
+		Query:             b.DefaultPlan.SQL,
+		PlanSpaceCount:    len(b.Plans),
+		RawPlanSpaceCount: b.RawPlanCount,
+		DefaultPlanId:     int(b.DefaultPlan.Plan),
+		DefaultPlanDur:    b.DefaultPlan.Cost.Mean,
+		DefaultPlanDurDev: b.DefaultPlan.Cost.Diff(),
+		BestPlanDur:       bestPlan.Cost.Mean,
+		BestPlanDurDev:    bestPlan.Cost.Diff(),
+		DefaultPlanServer: defaultPlan.Server,
+		BestPlanServer:    bestPlan.Server,
+		OptimalPlan:       optimalPlan,
+		DefaultPlanRounds: len(defaultPlan.Cost.Values),
+		DefaultRejected:   defaultPlan.Cost.Rejected(),
+		PlanTests:         tests,
+		BestPlanHintDiff:  defaultPlan.Hints.Diff(bestPlan.Hints).String(),
+		CaptureDir:        captureDir,
+		CensoredPlan:      censoredPlan,
+		CrashedPlan:       crashedPlan,
+		DominantOperator:  dominantOperator(defaultPlan, bestPlan),
+		PlanCacheHits:     planCacheHits,
+		Retries:           b.Retries,
+		Effectiveness:     float64(planSpaceCount-betterPlanCount) / float64(planSpaceCount),
+		EstRowsQError: map[string]float64{
+			"count": float64(len(baseTableMetrics.Values)),
+			"50th":  baseTableMetrics.quantile(0.5),
+			"90th":  baseTableMetrics.quantile(0.5),
+			"95th":  baseTableMetrics.quantile(0.5),
+			"max":   baseTableMetrics.quantile(1),
+		},
+	}
+	return &row
+}
+
+// dominantOperator finds the operator whose exclusive time grows the most from the best plan to the default plan
+func dominantOperator(defaultPlan, bestPlan *Bench) string {
+	if defaultPlan == bestPlan || defaultPlan.Analysis == nil || bestPlan.Analysis == nil {
+		return ""
+	}
+	gaps := make(map[string]time.Duration)
+	defaultPlan.Analysis.Walk(func(info *executor.ExplainAnalyzeInfo) {
+		gaps[info.Op] += info.ExclusiveTime()
+	})
+	bestPlan.Analysis.Walk(func(info *executor.ExplainAnalyzeInfo) {
+		gaps[info.Op] -= info.ExclusiveTime()
+	})
+	var op string
+	var gap time.Duration
+	for o, g := range gaps {
+		if g > gap || (g == gap && g > 0 && o < op) {
+			op, gap = o, g
+		}
+	}
+	if op == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s(+%v)", op, gap)
 }
 
 func (t Table) String() string {
+	var builder strings.Builder
+	for _, session := range t.Sessions {
+		if len(session.Variables) != 0 {
+			builder.WriteString(session.String())
+			builder.WriteString("\n")
+		}
+	}
+	builder.WriteString(fmt.Sprintf("judge: %s\n", t.Judge))
 	w := table.NewWriter()
 	var headers table.Row
 	for _, h := range t.Headers {
@@ -127,5 +289,19 @@ func (t Table) String() string {
 	for _, row := range t.Rows {
 		w.AppendRow(row.toTableRows())
 	}
-	return w.Render()
+	builder.WriteString(w.Render())
+	return builder.String()
+}
+
+func (s Session) String() string {
+	names := make([]string, 0, len(s.Variables))
+	for name := range s.Variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	variables := make([]string, 0, len(names))
+	for _, name := range names {
+		variables = append(variables, fmt.Sprintf("%s=%s", name, s.Variables[name]))
+	}
+	return fmt.Sprintf("session variables of %s: %s", s.Dsn, strings.Join(variables, ", "))
 }
@@ -0,0 +1,37 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package loader
+
+import (
+	"github.com/pingcap/parser/ast"
+	log "github.com/sirupsen/logrus"
+)
+
+// SkipLoader skips queries whose IDs are in Skip, like queries finished by a previous run
+type SkipLoader struct {
+	QueryLoader
+	Skip map[string]bool
+}
+
+func (l *SkipLoader) Next() (string, ast.StmtNode) {
+	for {
+		queryID, stmt := l.QueryLoader.Next()
+		if stmt == nil || !l.Skip[queryID] {
+			return queryID, stmt
+		}
+		log.WithFields(log.Fields{
+			"query id": queryID,
+		}).Info("skip the finished query")
+	}
+}